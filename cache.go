@@ -7,4 +7,12 @@ type storedCache struct {
 	OptionSeries   OptionSeriesCache         `json:"optionSeries"`
 	OptionChainGet OptionChainGetStoredCache `json:"optionChainGet"`
 	OptionQuote    OptionQuoteCache          `json:"optionQuote"`
+
+	InstrumentDetails InstrumentDetailsStoredCache `json:"instrumentDetails"`
+
+	// response is set for services registered with RegisterService
+	response *Response
+
+	// err is set instead of the data when the gateway responded with an error
+	err error
 }
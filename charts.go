@@ -2,13 +2,11 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/Jeffail/gabs/v2"
-	jsonpatch "github.com/evanphx/json-patch"
 )
 
 // ChartRequestSignature is the parameter for a chart request
@@ -22,13 +20,29 @@ type ChartRequestSignature struct {
 	// width is the width of the candles to be received, see specs.txt
 	Width string
 
+	// studies for the provisioner to compute alongside the candles, their
+	// output is not decoded into the chart yet
+	Studies []ChartStudy
+
+	// extended hours includes pre-market and after-hours candles, without it
+	// only the candles of regular trading hours are sent
+	ExtendedHours bool
+
 	// internal use only
 	UniqueID string
 }
 
-// shortname presented as CHART#TICKER@RANGE:WIDTH
+// shortname presented as CHART#TICKER@RANGE:WIDTH, followed by +EXT for
+// extended hours and the studies in brackets if there are any
 func (c *ChartRequestSignature) shortName() string {
-	return fmt.Sprintf("CHART#%s@%s:%s", c.Ticker, c.Range, c.Width)
+	name := fmt.Sprintf("CHART#%s@%s:%s", c.Ticker, c.Range, c.Width)
+	if c.ExtendedHours {
+		name += "+EXT"
+	}
+	if len(c.Studies) > 0 {
+		name += fmt.Sprintf("[%s]", strings.Join(studyNames(c.Studies), ";"))
+	}
+	return name
 }
 
 // ChartStoredCache is an object containing what is returned from a chart request
@@ -46,65 +60,158 @@ type ChartStoredCache struct {
 	RequestVer int    `json:"requestVer"`
 }
 
-type newChartObject struct {
-	Op    string           `json:"op"`
-	Path  string           `json:"path"`
-	Value ChartStoredCache `json:"value"`
+// Candle is a single candle of a chart, the timestamp is in milliseconds
+type Candle struct {
+	Timestamp int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
 }
 
-type updateChartObject struct {
-	Op    string `json:"op"`
-	Path  string `json:"path"`
-	Value int    `json:"value"`
+// Time returns the timestamp of the candle as a time.Time
+func (c Candle) Time() time.Time {
+	return time.Unix(0, c.Timestamp*int64(time.Millisecond))
 }
 
-func (s *Session) chartHandler(msg []byte, gab *gabs.Container) {
+// Candle returns the candle at index i of the chart
+func (c *ChartStoredCache) Candle(i int) Candle {
+	candle := Candle{}
+	if i < len(c.Candles.Timestamps) {
+		candle.Timestamp = c.Candles.Timestamps[i]
+	}
+	if i < len(c.Candles.Opens) {
+		candle.Open = c.Candles.Opens[i]
+	}
+	if i < len(c.Candles.Highs) {
+		candle.High = c.Candles.Highs[i]
+	}
+	if i < len(c.Candles.Lows) {
+		candle.Low = c.Candles.Lows[i]
+	}
+	if i < len(c.Candles.Closes) {
+		candle.Close = c.Candles.Closes[i]
+	}
+	if i < len(c.Candles.Volumes) {
+		candle.Volume = c.Candles.Volumes[i]
+	}
+	return candle
+}
 
-	rID := gab.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
+// ChartUpdate is sent on a chart subscription for every candle that is added
+// or updated after the initial snapshot
+type ChartUpdate struct {
+	Symbol string
+	Index  int
+	Candle
 
-	newState := s.CurrentState
-	// get the patches
-	patches := gab.S("body", "patches").Children()
-	for _, patch := range patches {
-		var err error
+	// New is true if the candle was added by this update
+	New bool
 
-		if patch.S("path").String() == "/error" {
-			continue
-		}
+	// Closed is true once a later candle exists, the candle that was forming
+	// is sent once more with Closed set when the candle after it is added
+	Closed bool
 
-		path := patch.S("path").String()
-		if path == "\"\"" {
-			newState.Chart = ChartStoredCache{}
-		}
-		patch.Set(fmt.Sprintf("/chart%s", path[1:len(path)-1]), "path")
+	// Err is set (and nothing else) when the provisioner responded with a
+	// *ServiceError or the request could not be sent, a request that could
+	// not be sent is sent again once the session reconnects
+	Err error
+}
+
+// chartUpdates compares two versions of a chart and returns an update for
+// every candle that was added or changed
+func chartUpdates(before, after *ChartStoredCache) []ChartUpdate {
+	updates := []ChartUpdate{}
 
-		bytesJSON, _ := patch.MarshalJSON()
-		patchStr := "[" + string(bytesJSON) + "]"
-		jspatch, err := jsonpatch.DecodePatch([]byte(patchStr))
+	previous := len(before.Candles.Timestamps)
+	current := len(after.Candles.Timestamps)
+	for i := 0; i < current; i++ {
+		candle := after.Candle(i)
 
-		if err != nil {
+		isNew := i >= previous
+		justClosed := i == previous-1 && current > previous
+		if !isNew && !justClosed && candle == before.Candle(i) {
 			continue
 		}
 
-		byteState, _ := json.Marshal(newState)
+		updates = append(updates, ChartUpdate{
+			Symbol: after.Symbol,
+			Index:  i,
+			Candle: candle,
+			New:    isNew,
+			Closed: i < current-1,
+		})
+	}
 
-		byteState, err = jspatch.Apply(byteState)
-		if err != nil {
-			continue
+	return updates
+}
+
+func (s *Session) chartHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+
+	// subscriptions need the chart from before the patches to tell which
+	// candles changed
+	var before ChartStoredCache
+	sub, subscribed := s.subscriptions.get(key)
+	subscribed = subscribed && s.state.get(key, &before)
+
+	if _, ok := s.state.apply(key, gab); !ok {
+		return
+	}
+
+	var chart ChartStoredCache
+	s.state.get(key, &chart)
+	chart.RequestID = key.ID
+
+	if subscribed {
+		for _, update := range chartUpdates(&before, &chart) {
+			sub.events.push(update)
 		}
+	}
 
-		json.Unmarshal(byteState, &newState)
-		newState.Chart.RequestID = rID
+	s.router.deliver(key, storedCache{Chart: chart})
+}
 
-		var newState storedCache
-		json.Unmarshal(byteState, &newState)
+// chartRequest builds the chart_v27 request for a spec
+func chartRequest(specs ChartRequestSignature, uniqueID string, ver int) gatewayRequest {
+	extendedHours := specs.ExtendedHours
+	return gatewayRequest{
+		Header: gatewayHeader{
+			Service: "chart_v27",
+			ID:      uniqueID,
+			Ver:     ver,
+		},
+		Params: gatewayParams{
+			Symbol:            specs.Ticker,
+			AggregationPeriod: specs.Width,
+			Range:             specs.Range,
+			Studies:           studyNames(specs.Studies),
+			ExtendedHours:     &extendedHours,
+		},
+	}
+}
 
-		s.CurrentState = newState
+// cachedChart returns the chart held for the latest request made for a spec,
+// the provisioner keeps this up to date with patches after the first response
+func (s *Session) cachedChart(specs ChartRequestSignature) (*ChartStoredCache, bool) {
+	ver, ok := s.versions.last(specs.shortName())
+	if !ok {
+		return nil, false
+	}
 
+	key := stateKey{
+		Service: "chart_v27",
+		ID:      fmt.Sprintf("%s-%d", specs.shortName(), ver),
 	}
 
-	s.TransactionChannel <- newState
+	var chart ChartStoredCache
+	if !s.state.get(key, &chart) {
+		return nil, false
+	}
+	chart.RequestID = key.ID
+
+	return &chart, true
 }
 
 // RequestChart takes a ChartRequestSignature as an input and responds with a
@@ -112,67 +219,92 @@ func (s *Session) chartHandler(msg []byte, gab *gabs.Container) {
 // else it makes a new request and waits for it - if a ticker does not load in
 // time, ErrNotReceviedInTime is sent as an error
 func (s *Session) RequestChart(specs ChartRequestSignature) (*ChartStoredCache, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestChartContext(ctx, specs)
+}
+
+// RequestChartContext is the same as RequestChart but honours the deadline and
+// cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestChartContext(ctx context.Context, specs ChartRequestSignature) (chart *ChartStoredCache, err error) {
+	defer s.observeRequest("RequestChart", time.Now(), &err)
 
 	// force capitalization of tickers, since the socket is case sensitive
 	specs.Ticker = strings.ToUpper(specs.Ticker)
 
-	if s.CurrentState.Chart.RequestID == specs.shortName() {
-		return &s.CurrentState.Chart, nil
+	if chart, ok := s.cachedChart(specs); ok {
+		return chart, nil
 	}
 
-	uniqueID := fmt.Sprintf("%s-%d", specs.shortName(), s.ChartRequestVers[specs.shortName()])
+	ver := s.versions.next(specs.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", specs.shortName(), ver)
 
 	payload := gatewayRequestLoad{
 		Payload: []gatewayRequest{
-			{
-				Header: gatewayHeader{
-					Service: "chart_v27",
-					ID:      uniqueID,
-					Ver:     int(s.ChartRequestVers[specs.shortName()]),
-				},
-				Params: gatewayParams{
-					Symbol:            specs.Ticker,
-					AggregationPeriod: specs.Width,
-					Range:             specs.Range,
-					Studies:           []string{},
-					ExtendedHours:     true,
-				},
-			},
+			chartRequest(specs, uniqueID, ver),
 		},
 	}
 
-	s.ChartRequestVers[specs.shortName()]++
-	s.sendJSON(payload)
-
-	internalChannel := make(chan storedCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second)
-	defer ctxCancel()
-
-	go func() {
-		for {
-			select {
-
-			case recvPayload := <-s.TransactionChannel:
-				if recvPayload.Chart.RequestID == uniqueID {
-					internalChannel <- recvPayload
-					return
-				}
+	key := stateKey{Service: "chart_v27", ID: uniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
 
-			case <-ctx.Done():
-				break
-			}
-		}
-	}()
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
 	select {
 
-	case recvPayload := <-internalChannel:
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
 		return &recvPayload.Chart, nil
 
 	case <-ctx.Done():
-		return nil, ErrNotReceivedInTime
+		return nil, s.requestFailed(ctx, key, "symbol", specs.Ticker)
+	}
+}
+
+// SubscribeChart requests a chart and keeps it subscribed, every candle that
+// is added or updated after the initial snapshot is sent on the returned
+// channel until cancel is called (which closes the channel). The snapshot
+// itself can be read with RequestChart using the same spec
+func (s *Session) SubscribeChart(specs ChartRequestSignature) (<-chan ChartUpdate, func()) {
+	// force capitalization of tickers, since the socket is case sensitive
+	specs.Ticker = strings.ToUpper(specs.Ticker)
+
+	ver := s.versions.next(specs.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", specs.shortName(), ver)
+	sub := s.subscribe(stateKey{Service: "chart_v27", ID: uniqueID})
+
+	if err := s.sendSubscription(sub, chartRequest(specs, uniqueID, ver)); err != nil {
+		sub.events.push(err)
 	}
 
+	updates := make(chan ChartUpdate)
+	go func() {
+		defer close(updates)
+		sub.events.drain(func(v interface{}, done <-chan struct{}) {
+			update, ok := v.(ChartUpdate)
+			if err, failed := v.(error); failed {
+				update, ok = ChartUpdate{Err: err}, true
+			}
+			if !ok {
+				return
+			}
+
+			select {
+			case updates <- update:
+			case <-done:
+			}
+		})
+	}()
+
+	return updates, func() {
+		s.unsubscribe(sub)
+	}
 }
 
 // RequestMultipleCharts takes a slice of ChartRequestSignature as an input and
@@ -180,86 +312,80 @@ func (s *Session) RequestChart(specs ChartRequestSignature) (*ChartStoredCache,
 // (with updated diffs), or else it makes a new request and waits for it - if a
 // ticker does not load in time, ErrNotReceviedInTime is sent as an error
 func (s *Session) RequestMultipleCharts(specsSlice []ChartRequestSignature) ([]*ChartStoredCache, []ChartRequestSignature) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestMultipleChartsContext(ctx, specsSlice)
+}
 
-	for {
-		if s.MutexLock == false {
-			break
-		} else {
-			time.Sleep(50 * time.Millisecond)
-		}
-	}
-
+// RequestMultipleChartsContext is the same as RequestMultipleCharts but honours
+// the deadline and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestMultipleChartsContext(ctx context.Context, specsSlice []ChartRequestSignature) ([]*ChartStoredCache, []ChartRequestSignature) {
 	payload := gatewayRequestLoad{}
 	response := []*ChartStoredCache{}
 	erroredTickers := []ChartRequestSignature{}
 	uniqueSpecs := []ChartRequestSignature{}
+	waiters := []<-chan storedCache{}
+
+	// the call is reported as timed out if any chart timed out, otherwise
+	// with the first service or send error
+	var err, timeout error
+	defer func(start time.Time) {
+		if timeout != nil {
+			err = timeout
+		}
+		s.metrics.RequestCompleted("RequestMultipleCharts", time.Since(start), err)
+	}(time.Now())
 
 	for _, spec := range specsSlice {
 		// force capitalization of tickers, since the socket is case sensitive
 		spec.Ticker = strings.ToUpper(spec.Ticker)
 
-		if s.CurrentState.Chart.RequestID == spec.shortName() {
-			response = append(response, &s.CurrentState.Chart)
+		if chart, ok := s.cachedChart(spec); ok {
+			response = append(response, chart)
+			continue
 		}
 
-		spec.UniqueID = fmt.Sprintf("%s-%d", spec.shortName(), s.ChartRequestVers[spec.shortName()])
+		ver := s.versions.next(spec.shortName())
+		spec.UniqueID = fmt.Sprintf("%s-%d", spec.shortName(), ver)
 		uniqueSpecs = append(uniqueSpecs, spec)
 
-		req := gatewayRequest{
-			Header: gatewayHeader{
-				Service: "chart",
-				ID:      spec.UniqueID,
-				Ver:     int(s.ChartRequestVers[spec.shortName()]),
-			},
-			Params: gatewayParams{
-				Symbol:            spec.Ticker,
-				AggregationPeriod: spec.Width,
-				Studies:           []string{},
-				Range:             spec.Range,
-			},
-		}
-		s.ChartRequestVers[spec.shortName()]++
+		key := stateKey{Service: "chart_v27", ID: spec.UniqueID}
+		waiters = append(waiters, s.router.register(key))
+		defer s.router.unregister(key)
 
-		payload.Payload = append(payload.Payload, req)
+		payload.Payload = append(payload.Payload, chartRequest(spec, spec.UniqueID, ver))
 	}
 
-	s.wsConn.WriteJSON(payload)
+	if len(payload.Payload) == 0 {
+		return response, erroredTickers
+	}
 
-	internalChannel := make(chan []*ChartStoredCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second)
-	defer ctxCancel()
+	if err = s.sendJSON(payload); err != nil {
+		erroredTickers = append(erroredTickers, uniqueSpecs...)
+		return response, erroredTickers
+	}
 
-	go func() {
-		for {
-			select {
+	// every request has been sent, so the responses can be waited on in turn
+	// against the shared deadline
+	for i, spec := range uniqueSpecs {
+		select {
 
-			case recvPayload := <-s.TransactionChannel:
-				for index, spec := range uniqueSpecs {
-					if recvPayload.Chart.RequestID == spec.UniqueID {
-						response = append(response, &recvPayload.Chart)
-						uniqueSpecs = append(uniqueSpecs[:index], uniqueSpecs[index+1:]...)
-						if len(uniqueSpecs) == 0 {
-							internalChannel <- response
-						}
-						break
-					}
+		case recvPayload := <-waiters[i]:
+			if recvPayload.err != nil {
+				if err == nil {
+					err = recvPayload.err
 				}
-
-			case <-ctx.Done():
-				break
+				erroredTickers = append(erroredTickers, spec)
+				continue
 			}
-		}
-	}()
+			response = append(response, &recvPayload.Chart)
 
-	select {
-
-	case recvPayload := <-internalChannel:
-		return recvPayload, erroredTickers
-
-	case <-ctx.Done():
-		for _, spec := range uniqueSpecs {
+		case <-ctx.Done():
+			timeout = s.requestFailed(ctx, stateKey{Service: "chart_v27", ID: spec.UniqueID}, "symbol", spec.Ticker)
 			erroredTickers = append(erroredTickers, spec)
 		}
-		return response, erroredTickers
 	}
+
+	return response, erroredTickers
 }
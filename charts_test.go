@@ -0,0 +1,87 @@
+package flux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestChartExtendedHours(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}))
+	srv.Handle("instrument_search", fluxtest.Search("AAPL"))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	specs := []ChartRequestSignature{
+		{Ticker: "AAPL", Range: "DAY1", Width: "MIN5"},
+		{Ticker: "AAPL", Range: "DAY1", Width: "MIN5", ExtendedHours: true},
+	}
+	if _, err := s.RequestChart(specs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, errored := s.RequestMultipleCharts(specs[1:]); len(errored) != 0 {
+		t.Fatalf("%d charts errored", len(errored))
+	}
+
+	// regular hours are asked for explicitly rather than left to the
+	// provisioner's default
+	requests := srv.Requests("chart_v27")
+	if len(requests) != len(specs) {
+		t.Fatalf("expected %d chart requests, got %d", len(specs), len(requests))
+	}
+	for i, req := range requests {
+		var extendedHours bool
+		if !req.Param("extendedHours", &extendedHours) || extendedHours != specs[i].ExtendedHours {
+			t.Fatalf("request %d: expected extendedHours %t in %s", i, specs[i].ExtendedHours, req.Params)
+		}
+	}
+
+	if _, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAPL"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range srv.Requests("instrument_search") {
+		var extendedHours bool
+		if req.Param("extendedHours", &extendedHours) {
+			t.Fatalf("extendedHours was sent with a search: %s", req.Params)
+		}
+	}
+}
+
+func TestChartStudiesRequested(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	spec := ChartRequestSignature{
+		Ticker: "AAPL", Range: "DAY1", Width: "HOUR1",
+		Studies: []ChartStudy{SimpleMovingAverage(20), VWAPStudy(), {Name: "RSI", Inputs: []string{"14", "70", "30"}}},
+	}
+	if _, err := s.RequestChart(spec); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"SimpleMovingAvg(close,20)", "VWAP", "RSI(14,70,30)"}
+	var studies []string
+	req, ok := srv.WaitRequest("chart_v27", 0, time.Second)
+	if !ok || !req.Param("studies", &studies) || len(studies) != len(want) {
+		t.Fatalf("expected studies %v in %s", want, req.Params)
+	}
+	for i := range want {
+		if studies[i] != want[i] {
+			t.Fatalf("expected studies %v, got %v", want, studies)
+		}
+	}
+
+	if name := spec.shortName(); name != "CHART#AAPL@DAY1:HOUR1[SimpleMovingAvg(close,20);VWAP;RSI(14,70,30)]" {
+		t.Fatalf("unexpected short name %s", name)
+	}
+}
@@ -1,10 +1,9 @@
 package flux
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/Jeffail/gabs/v2"
@@ -13,48 +12,55 @@ import (
 )
 
 // New takes the input of a tda.Session (see github.com/adityaxdiwakar/tda-go)
-// and returns a flux Session which is used for all essentially library uses
-func New(creds tda.Session, debug bool) (*Session, error) {
+// and returns a flux Session which is used for all essentially library uses,
+// the defaults can be changed with options
+func New(creds tda.Session, opts ...Option) (*Session, error) {
 	s := &Session{
-		TdaSession: creds,
+		TdaSession:         creds,
+		dialer:             WebsocketDialer{},
+		ConfigURL:          "https://trade.thinkorswim.com/v1/api/config",
+		ProtocolVersion:    "26.*.*",
+		requestTimeout:     time.Second,
+		reconnectPolicy:    DefaultReconnectPolicy(),
+		heartbeatInterval:  5 * time.Second,
+		heartbeatTolerance: 3,
+		tokenLifetime:      30 * time.Minute,
+		tokenRefreshAhead:  5 * time.Minute,
+		tokens:             &tokenManager{},
+		eventStream:        &eventStream{},
+		router:             newRouter(),
+		subscriptions:      newSubscriptions(),
+		services:           newServices(),
+		metrics:            nopMetrics{},
 	}
-	if _, err := s.TdaSession.GetAccessToken(); err != nil {
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger == nil {
+		s.logger = NewStdLogger(nil, s.debug)
+	}
+	s.state = newStateStore(s.metrics)
+	s.registerBuiltins()
+
+	if _, err := s.refreshAccessToken(); err != nil {
 		return nil, err
 	}
 
-	s.DebugFlag = debug
-	s.ConfigURL = "https://trade.thinkorswim.com/v1/api/config"
-	s.CurrentState = storedCache{}
-	s.TransactionChannel = make(chan storedCache, 5)
-	s.NotificationChannel = make(chan bool, 50)
-	s.ChartRequestVers = make(map[string]int)
-	s.QuoteRequestVers = make(map[string]int)
-	s.OptionQuoteRequestVers = make(map[string]int)
-	s.SearchRequestVers = make(map[string]int)
-	s.OptionSeriesRequestVers = make(map[string]int)
-	s.OptionChainGetRequestVers = make(map[string]int)
-	s.Established = false
+	s.versions = newRequestVersions()
 
 	return s, nil
 }
 
-// Reset resets the state; it does not reset the connection
+// Reset resets the state; it does not reset the connection. The documents of
+// active subscriptions and the request versions are kept, so that the
+// subscriptions can be restored once the connection is opened again
 func (s *Session) Reset() error {
-	if _, err := s.TdaSession.GetAccessToken(); err != nil {
+	if _, err := s.refreshAccessToken(); err != nil {
 		return err
 	}
 
-	s.ConfigURL = "https://trade.thinkorswim.com/v1/api/config"
-	s.CurrentState = storedCache{}
-	s.TransactionChannel = make(chan storedCache, 5)
-	s.NotificationChannel = make(chan bool, 50)
-	s.ChartRequestVers = make(map[string]int)
-	s.QuoteRequestVers = make(map[string]int)
-	s.OptionQuoteRequestVers = make(map[string]int)
-	s.SearchRequestVers = make(map[string]int)
-	s.OptionSeriesRequestVers = make(map[string]int)
-	s.OptionChainGetRequestVers = make(map[string]int)
-	s.Established = false
+	s.state.retain(s.subscriptions.keys())
 
 	return nil
 }
@@ -62,45 +68,63 @@ func (s *Session) Reset() error {
 // Open is a method that opens the websocket connection with the TDAmeritrade
 // server and returns an error if it is present
 func (s *Session) Open() error {
-	var err error
+	s.connMu.Lock()
+	s.shutdown = false
+	s.err = nil
+	s.connMu.Unlock()
 
-	// if connection is open, bail out here
-	if s.wsConn != nil {
+	return s.open()
+}
+
+// open connects and logs in, the connection is only handed to requests once
+// the login has been accepted
+func (s *Session) open() (err error) {
+	// if connection is open (or being opened), bail out here
+	s.connMu.Lock()
+	if s.wsConn != nil || s.opening {
+		s.connMu.Unlock()
 		return ErrWsAlreadyOpen
 	}
+	s.opening = true
+	s.connMu.Unlock()
 
-	// get the gateway url from the configuration endpoint
-	gateway, err := s.Gateway()
-	if err != nil {
-		return err
+	defer func() {
+		s.connMu.Lock()
+		s.opening = false
+		s.connMu.Unlock()
+	}()
+
+	// get the gateway url from the configuration endpoint, unless the
+	// gateway has been set explicitly
+	gateway := s.GatewayURL
+	if gateway == "" {
+		gateway, err = s.Gateway()
+		if err != nil {
+			return err
+		}
 	}
 
-	// dial up the websocket dialer
-	s.wsConn, _, err = websocket.DefaultDialer.Dial(gateway, http.Header{})
+	// dial up the gateway through the session's dialer
+	conn, err := s.dialer.Dial(gateway, http.Header{})
 	if err != nil {
-		s.wsConn = nil
 		return err
 	}
 
-	s.wsConn.SetCloseHandler(func(code int, text string) error {
-		return nil
-	})
-
 	defer func() {
 		if err != nil {
-			s.wsConn.Close()
-			s.wsConn = nil
+			conn.Close()
 		}
 	}()
 
 	// initial message to be sent to receive a protocol message from the server
 	establishProtocolPacket := protocolPacketData{
-		Ver:       "26.*.*",
+		Ver:       s.ProtocolVersion,
 		Fmt:       "json-patches-structured",
-		Heartbeat: "5s",
+		Heartbeat: s.heartbeatInterval.String(),
 	}
 
-	err = s.wsConn.WriteJSON(establishProtocolPacket)
+	s.recorder.recordJSON(DirectionOut, establishProtocolPacket)
+	err = conn.WriteJSON(establishProtocolPacket)
 	if err != nil {
 		return ErrProtocolUnestablished
 	}
@@ -108,7 +132,13 @@ func (s *Session) Open() error {
 	// read the response from the server and parse it as a protocol response,
 	// error if all fields are empty
 	var establishedProtocolResponse protocolResponse
-	err = s.wsConn.ReadJSON(&establishedProtocolResponse)
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return ErrProtocolUnestablished
+	}
+	s.recorder.record(DirectionIn, message)
+
+	err = json.Unmarshal(message, &establishedProtocolResponse)
 	if err != nil {
 		return ErrProtocolUnestablished
 	}
@@ -117,13 +147,101 @@ func (s *Session) Open() error {
 		return ErrProtocolUnestablished
 	}
 
+	s.connMu.Lock()
+	s.serverInfo = ServerInfo{
+		Gateway: gateway,
+		Session: establishedProtocolResponse.Session,
+		Build:   establishedProtocolResponse.Build,
+		Ver:     establishedProtocolResponse.Ver,
+	}
+	s.connMu.Unlock()
+
+	s.emit(Connected{
+		Gateway: gateway,
+		Session: establishedProtocolResponse.Session,
+		Build:   establishedProtocolResponse.Build,
+	})
+
 	// use github.com/adityaxdiwkar/tda-go to retrieve access token using
-	// session credentials
-	accessToken, err := s.TdaSession.GetAccessToken()
+	// session credentials, unless the current one is still fresh
+	accessToken, err := s.accessToken()
 	if err != nil {
 		return err
 	}
 
+	// push the authentication into the stream
+	err = s.sendLogin(conn, accessToken)
+	if err != nil {
+		return ErrAuthenticationUnsuccessful
+	}
+
+	// nothing can be requested until the gateway accepts the login
+	err = s.awaitLogin(conn)
+	if err != nil {
+		return err
+	}
+
+	// hand the connection to requests (unless the session was closed while it
+	// was being opened) and resubscribe to anything that was subscribed
+	// before a reconnect, both under the write lock so that a subscription
+	// made meanwhile is either restored or sent by itself, never both
+	s.writeMu.Lock()
+	s.connMu.Lock()
+	if s.shutdown {
+		s.connMu.Unlock()
+		s.writeMu.Unlock()
+		return ErrConnClosed
+	}
+	s.wsConn = conn
+	s.established = true
+	s.lastHeartbeat = time.Now()
+	s.connMu.Unlock()
+
+	err = s.restoreSubscriptions(conn)
+	s.writeMu.Unlock()
+	if err != nil {
+		s.dropConn(conn)
+		return err
+	}
+
+	// launch goroutines to handle and listen the stream
+	done := make(chan struct{})
+	go s.listen(conn, done)
+	go s.watchHeartbeats(conn, done)
+	go s.refreshTokens(conn, done)
+
+	return nil
+}
+
+// Established is true while the Session has an open, logged in connection
+func (s *Session) Established() bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.established
+}
+
+// conn returns the open connection, nil while there is none
+func (s *Session) conn() Conn {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.wsConn
+}
+
+// dropConn closes a connection and stops handing it to requests
+func (s *Session) dropConn(conn Conn) {
+	s.connMu.Lock()
+	if s.wsConn == conn {
+		s.wsConn = nil
+		s.established = false
+	}
+	s.connMu.Unlock()
+
+	conn.Close()
+}
+
+// sendLogin sends the login request for an access token on a connection, the
+// access token is left out of recordings
+func (s *Session) sendLogin(conn Conn, accessToken string) error {
 	request := gatewayRequestLoad{
 		Payload: []gatewayRequest{
 			{
@@ -134,7 +252,7 @@ func (s *Session) Open() error {
 				},
 				Params: gatewayParams{
 					Domain:      "TOS",
-					Platform:    "PROD",
+					Platform:    s.Environment.platform(),
 					AccessToken: accessToken,
 					Tag:         "TOSWeb",
 				},
@@ -142,133 +260,182 @@ func (s *Session) Open() error {
 		},
 	}
 
-	// push the authentication into the stream
-	err = s.wsConn.WriteJSON(request)
-	if err != nil {
-		return ErrAuthenticationUnsuccessful
-	}
-
-	// launch goroutine to handle and listen the stream
-	go s.listen()
-	go s.reconnectHandler()
+	redacted := request
+	redacted.Payload = []gatewayRequest{request.Payload[0]}
+	redacted.Payload[0].Params.AccessToken = "REDACTED"
 
-	s.Established = true
-	return nil
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.recorder.recordJSON(DirectionOut, redacted)
+	return conn.WriteJSON(request)
 }
 
+// loginTimeout is how long Open waits for the gateway to respond to the login
+const loginTimeout = 10 * time.Second
+
 func (s *Session) sendJSON(v interface{}) error {
-	s.Mu.Lock()
-	defer s.Mu.Unlock()
-	return s.wsConn.WriteJSON(v)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.writeJSON(v)
 }
 
-func (s *Session) reconnectHandler() {
-	// latch to only establish a single handler (singleton)
-	if s.HandlerWorking == true {
-		return
+// writeJSON sends v on the open connection, s.writeMu has to be held
+func (s *Session) writeJSON(v interface{}) error {
+	conn := s.conn()
+	if conn == nil {
+		return ErrConnClosed
 	}
-	s.HandlerWorking = true
-
-	// at the end of this function, delete this handler
-	defer func() {
-		s.HandlerWorking = false
-	}()
 
-	// reconnect every 20 minutes
-	for range time.Tick(20 * time.Minute) {
-		s.MutexLock = true
-		s.Mu.Lock()
-		fmt.Printf("\n")
-		log.Println("[FLUX] Restarting connection, attempting disconnect...")
-		s.Close()
-		log.Println("[FLUX] Attempting reconnect...")
-		s.Open()
-		log.Println("[FLUX] Connected")
-		time.Sleep(250 * time.Millisecond)
-		s.Mu.Unlock()
-		s.MutexLock = false
-	}
+	s.recorder.recordJSON(DirectionOut, v)
+	return conn.WriteJSON(v)
 }
 
-// Close sends a websocket.CloseMessage to the server and waits for closure
+// Close sends a websocket.CloseMessage to the server and waits for closure,
+// the session does not reconnect until it is opened again
 func (s *Session) Close() error {
-	if s.wsConn != nil {
-		s.Established = false
-		s.wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1000, ""))
-
-		time.Sleep(time.Second)
+	s.connMu.Lock()
+	s.shutdown = true
+	conn := s.wsConn
+	s.wsConn = nil
+	s.established = false
+	s.connMu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
 
-		err := s.wsConn.Close()
-		if err != nil {
-			return err
-		}
+	s.writeMu.Lock()
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1000, ""))
+	s.writeMu.Unlock()
 
-		s.wsConn = nil
-	}
+	time.Sleep(time.Second)
 
-	return nil
+	return conn.Close()
 }
 
-func (s *Session) listen() {
+func (s *Session) listen(conn Conn, done chan struct{}) {
+	defer close(done)
+
 	for {
 
-		_, message, err := s.wsConn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			if s.MutexLock == true {
-				log.Printf("[FLUX] Disconnected with routine restart imminent...")
-			} else {
-				log.Printf("error: closing websocket listen due to %v", err)
-				// trying to connect again
-				s.Reset()
-				s.Open()
+			if s.isShutdown() {
+				s.emit(Disconnected{})
+				return
 			}
-			break
-		}
 
-		if s.DebugFlag {
-			fmt.Println(string(message))
-		}
+			s.emit(Disconnected{Err: err})
+			s.logger.Error("connection lost", "err", err)
 
-		if strings.Contains(string(message), "heartbeat") {
-			continue
+			// drop the dead connection and try to connect again
+			s.dropConn(conn)
+			s.reconnect(err)
+			return
 		}
 
-		parsedJSON, err := gabs.ParseJSON(message)
-		// TODO: handle this better rather than ignoring the message
-		if err != nil {
-			continue
-		}
+		s.alive()
+		s.recorder.record(DirectionIn, message)
+		s.handleMessage(message)
+	}
+}
 
-		for _, child := range parsedJSON.S("payload").Children() {
+// handleMessage hands every response in a message from the gateway to the
+// handler for its service
+func (s *Session) handleMessage(message []byte) {
+	if s.debug {
+		s.logger.Debug("received", "frame", string(message))
+	}
+
+	parsedJSON, err := gabs.ParseJSON(message)
+	// TODO: handle this better rather than ignoring the message
+	if err != nil {
+		return
+	}
+
+	// the listen loop has already taken note of the connection being alive
+	if parsedJSON.Exists("heartbeat") {
+		s.metrics.FrameReceived("heartbeat")
+		return
+	}
 
-			serviceType := child.Search("header", "service")
+	for _, child := range parsedJSON.S("payload").Children() {
 
-			switch serviceType.String() {
+		service, _ := child.Search("header", "service").Data().(string)
+		s.metrics.FrameReceived(service)
 
-			case `"login":`:
-				log.Println("Successfully logged in")
+		// the request failed, so the waiting caller gets the error instead of
+		// a document
+		if err := serviceError(child); err != nil {
+			s.logger.Warn("service error", "service", err.Service, "id", err.RequestID, "code", err.Code, "message", err.Message)
+			key := headerKey(child)
+			s.router.deliver(key, storedCache{err: err})
+			if sub, ok := s.subscriptions.get(key); ok {
+				sub.events.push(err)
+			}
+			continue
+		}
+		if s.debug {
+			s.logger.Debug("response", "service", service, "id", child.Search("header", "id").Data())
+		}
+
+		handler, ok := s.services.handler(service)
+		if !ok {
+			s.unhandledService(service, child)
+			continue
+		}
+		handler(message, child)
+	}
+}
 
-			case `"chart_v27"`:
-				// TODO: change this to chart_v27
-				s.chartHandler(message, child)
+func (s *Session) loginHandler(gab *gabs.Container) error {
+	var login loginResponse
+	json.Unmarshal(gab.S("body").Bytes(), &login)
 
-			case `"instrument_search"`:
-				s.searchHandler(message, child)
+	if !login.Authenticated {
+		s.logger.Error("login failed", "reason", login.Message)
+		s.emit(LoginFailed{Reason: login.Message})
 
-			case `"optionSeries"`:
-				s.optionSeriesHandler(message, child)
+		if login.Message == "" {
+			return ErrAuthenticationUnsuccessful
+		}
+		return fmt.Errorf("%w: %s", ErrAuthenticationUnsuccessful, login.Message)
+	}
 
-			case `"option_chain/get"`:
-				s.optionChainGetHandler(message, child)
+	s.logger.Info("logged in")
+	s.emit(LoggedIn{})
+	return nil
+}
 
-			case `"quotes"`:
-				go s.quoteHandler(message, child)
+// awaitLogin reads from the connection until the gateway responds to the
+// login request, anything else received in the meantime is handled as usual
+func (s *Session) awaitLogin(conn Conn) error {
+	// give up on a gateway that never responds by closing the connection
+	// under the pending read
+	timer := time.AfterFunc(loginTimeout, func() {
+		conn.Close()
+	})
+	defer timer.Stop()
 
-			case `"quotes/options"`:
-				s.optionQuoteHandler(message, child)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("%w: no login response: %v", ErrAuthenticationUnsuccessful, err)
+		}
+		s.recorder.record(DirectionIn, message)
 
+		parsedJSON, err := gabs.ParseJSON(message)
+		if err == nil {
+			for _, child := range parsedJSON.S("payload").Children() {
+				if child.Search("header", "service").Data() == "login" {
+					if s.debug {
+						s.logger.Debug("received", "frame", string(message))
+					}
+					return s.loginHandler(child)
+				}
 			}
-
 		}
+
+		s.handleMessage(message)
 	}
 }
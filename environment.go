@@ -0,0 +1,43 @@
+package flux
+
+// Environment selects the trading environment a Session connects to
+type Environment int
+
+const (
+	// LiveTrading connects to the live trading gateway, this is the default
+	LiveTrading Environment = iota
+
+	// PaperMoney connects to the paper trading gateway, requests are made
+	// against the paper accounts of the same login
+	PaperMoney
+)
+
+// String returns the name the configuration endpoint uses for the environment
+func (e Environment) String() string {
+	switch e {
+	case PaperMoney:
+		return "papermoney"
+	default:
+		return "livetrading"
+	}
+}
+
+// gateway picks the environment's gateway out of the configuration
+func (e Environment) gateway(config gatewayConfigResponse) string {
+	switch e {
+	case PaperMoney:
+		return config.MobileGatewayURL.Papermoney
+	default:
+		return config.MobileGatewayURL.Livetrading
+	}
+}
+
+// platform is the platform logged in to, the domain is TOS for both
+func (e Environment) platform() string {
+	switch e {
+	case PaperMoney:
+		return "PAPER"
+	default:
+		return "PROD"
+	}
+}
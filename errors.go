@@ -1,6 +1,10 @@
 package flux
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrWsAlreadyOpen is returned if the connection being opened is already opened
@@ -20,4 +24,35 @@ var (
 	// ErrNotReceivedInTime is returned if the data being loaded could not be
 	// found in the time enforcement
 	ErrNotReceivedInTime = errors.New("error: took too long to respond, try again")
+
+	// ErrReconnectFailed is returned by Session.Err once the ReconnectPolicy
+	// has run out of attempts
+	ErrReconnectFailed = errors.New("error: could not reconnect")
+
+	// ErrConnClosed is returned by in-memory connections once either side has
+	// been closed, and by requests made while the session is not connected
+	ErrConnClosed = errors.New("error: connection closed")
 )
+
+// ServiceError is returned by a request that the gateway responded to with an
+// error instead of data, such as a request for a symbol that does not exist
+type ServiceError struct {
+	Service   string
+	RequestID string
+	Code      int
+	Message   string
+}
+
+// Error describes the failed request and the gateway's message
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("error: %s request %s failed with code %d: %s", e.Service, e.RequestID, e.Code, e.Message)
+}
+
+// contextError is the error a request returns when its context is done, a
+// deadline that passes is reported as ErrNotReceivedInTime
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrNotReceivedInTime
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,112 @@
+package flux
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a change in the state of a Session's connection, read them from
+// Session.Events and switch on the concrete type
+type Event interface {
+	event()
+}
+
+// Connected is emitted once the connection to the gateway is open and the
+// protocol has been agreed on, the login follows
+type Connected struct {
+	Gateway string
+	Session string
+	Build   string
+}
+
+// LoggedIn is emitted when the gateway accepts the login
+type LoggedIn struct{}
+
+// LoginFailed is emitted when the gateway rejects the login, Reason is the
+// message the gateway gave (if any)
+type LoginFailed struct {
+	Reason string
+}
+
+// Disconnected is emitted when the connection is lost, Err is the error the
+// connection failed with or nil if the session was closed with Close
+type Disconnected struct {
+	Err error
+}
+
+// HeartbeatMissed is emitted every heartbeat interval that passes without a
+// heartbeat (or any other frame) from the server, Last is when the last frame
+// arrived. After HeartbeatTolerance misses the connection is torn down and
+// reconnected
+type HeartbeatMissed struct {
+	Last   time.Time
+	Missed int
+}
+
+// Reconnecting is emitted before every reconnect attempt, Err is the error
+// that caused the connection (or the previous attempt) to fail
+type Reconnecting struct {
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// ReconnectFailed is emitted when the ReconnectPolicy gives up, the Session
+// stays closed and Session.Err returns the same error
+type ReconnectFailed struct {
+	Attempts int
+	Err      error
+}
+
+func (Connected) event()       {}
+func (LoggedIn) event()        {}
+func (LoginFailed) event()     {}
+func (Disconnected) event()    {}
+func (HeartbeatMissed) event() {}
+func (Reconnecting) event()    {}
+func (ReconnectFailed) event() {}
+
+// eventStream hands events to the channel returned by Session.Events, events
+// are only kept once Events has been called
+type eventStream struct {
+	once   sync.Once
+	mu     sync.Mutex
+	events *queue
+	out    chan Event
+}
+
+// Events returns the channel that the Session's connection events are sent
+// on, events emitted before the first call are not kept. The channel is never
+// closed and never blocks the Session, events queue up until they are read
+func (s *Session) Events() <-chan Event {
+	st := s.eventStream
+	st.once.Do(func() {
+		events := newQueue(func(delta int) {
+			s.metrics.QueueDepthChanged("events", delta)
+		})
+		st.out = make(chan Event)
+
+		go events.drain(func(v interface{}, done <-chan struct{}) {
+			select {
+			case st.out <- v.(Event):
+			case <-done:
+			}
+		})
+
+		st.mu.Lock()
+		st.events = events
+		st.mu.Unlock()
+	})
+	return st.out
+}
+
+func (s *Session) emit(e Event) {
+	st := s.eventStream
+	st.mu.Lock()
+	events := st.events
+	st.mu.Unlock()
+
+	if events != nil {
+		events.push(e)
+	}
+}
@@ -34,8 +34,10 @@ func main() {
 			payload, err := s.RequestChart(flux.ChartRequestSignature{Ticker: input, Width: "HOUR1", Range: "DAY1"})
 			if err != nil {
 				fmt.Printf("Could not load ticker, %v\n", err)
+			} else if len(payload.Candles.Closes) == 0 {
+				fmt.Printf("No candles for %s\n", payload.Symbol)
 			} else {
-				fmt.Printf("%s\n", payload.Instrument.Description)
+				fmt.Printf("%s last closed at %.2f\n", payload.Symbol, payload.Candles.Closes[len(payload.Candles.Closes)-1])
 			}
 		}
 	}
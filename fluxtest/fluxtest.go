@@ -0,0 +1,411 @@
+// Package fluxtest provides an in-process fake of the TDAmeritrade provisioner
+// for testing code built on flux without a brokerage account. A Server serves
+// the thinkorswim configuration endpoint, the TDA token endpoint and a gateway
+// that speaks the json-patches-structured protocol, with scripted responses
+// per service.
+package fluxtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adityaxdiwakar/tda-go"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// AccessToken is the access token handed out by the fake token endpoint
+	AccessToken = "fluxtest-access-token"
+
+	// SessionToken is the token returned in a successful login response
+	SessionToken = "fluxtest-session-token"
+
+	// Build is the build reported in the protocol response
+	Build = "fluxtest"
+)
+
+// Responder builds the patches sent back for a request, returning no patches
+// sends nothing (which leaves the client waiting as the provisioner would)
+type Responder func(req Request) []Patch
+
+// Reply returns a Responder that always responds with the given patches
+func Reply(patches ...Patch) Responder {
+	return func(req Request) []Patch {
+		return patches
+	}
+}
+
+// Server is the fake provisioner, create one with NewServer and Close it when
+// the test is done
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	responders  map[string]Responder
+	conns       map[*conn]struct{}
+	requests    []Request
+	notify      chan struct{}
+	rejectLogin string
+	token       string
+	silent      bool
+}
+
+type conn struct {
+	ws       *websocket.Conn
+	mu       sync.Mutex
+	platform string
+}
+
+func (c *conn) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+// NewServer starts and returns a new fake provisioner
+func NewServer() *Server {
+	s := &Server{
+		responders: make(map[string]Responder),
+		conns:      make(map[*conn]struct{}),
+		notify:     make(chan struct{}),
+		token:      AccessToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/config", s.serveConfig)
+	mux.HandleFunc("/oauth2/token", s.serveToken)
+	mux.HandleFunc("/ws", s.serveGateway)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// ConfigURL is the URL of the fake thinkorswim configuration endpoint
+func (s *Server) ConfigURL() string {
+	return s.URL + "/v1/api/config"
+}
+
+// GatewayURL is the websocket URL of the fake gateway
+func (s *Server) GatewayURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+}
+
+// TdaSession returns credentials whose token requests go to the fake token
+// endpoint, pass them to flux.New along with flux.WithConfigURL(ConfigURL())
+func (s *Server) TdaSession() tda.Session {
+	return tda.Session{
+		Refresh:     "fluxtest-refresh-token",
+		ConsumerKey: "fluxtest",
+		RootUrl:     s.URL,
+	}
+}
+
+// Handle scripts the response for every request made to a service
+func (s *Server) Handle(service string, responder Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responders[service] = responder
+}
+
+// RejectLogin makes subsequent logins fail with the given message, an empty
+// message allows logins again
+func (s *Server) RejectLogin(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectLogin = message
+}
+
+// SetAccessToken changes the access token handed out by the token endpoint
+// (and accepted by the login service)
+func (s *Server) SetAccessToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Requests returns every request received so far for a service, in order
+func (s *Server) Requests(service string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []Request{}
+	for _, req := range s.requests {
+		if req.Header.Service == service {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// WaitRequest waits for the n-th (zero indexed) request to a service and
+// returns false if it was not received before the timeout
+func (s *Server) WaitRequest(service string, n int, timeout time.Duration) (Request, bool) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		notify := s.notify
+		s.mu.Unlock()
+
+		if requests := s.Requests(service); len(requests) > n {
+			return requests[n], true
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return Request{}, false
+		}
+	}
+}
+
+// SetHeartbeats turns the heartbeats sent at the interval each client asked
+// for on or off, they are on by default. Turning them off simulates a
+// connection that has silently died
+func (s *Server) SetHeartbeats(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = !enabled
+}
+
+// Connections returns the number of clients currently connected
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Push sends patches for a request to every connected client, this is how
+// subscriptions are updated after their initial snapshot
+func (s *Server) Push(header Header, patches ...Patch) {
+	s.broadcast(responseLoad{
+		Payload: []response{
+			{Header: header, Body: responseBody{Patches: patches}},
+		},
+	})
+}
+
+// Send writes an arbitrary frame to every connected client
+func (s *Server) Send(frame interface{}) {
+	s.broadcast(frame)
+}
+
+// Heartbeat sends a heartbeat to every connected client
+func (s *Server) Heartbeat() {
+	s.broadcast(heartbeat{Heartbeat: time.Now().UnixNano() / int64(time.Millisecond)})
+}
+
+// StartHeartbeats sends a heartbeat every interval until stop is called
+func (s *Server) StartHeartbeats(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Heartbeat()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Disconnect forcibly drops every connected client without a close frame, as
+// a network failure would
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[*conn]struct{})
+	s.mu.Unlock()
+
+	for c := range conns {
+		c.ws.UnderlyingConn().Close()
+	}
+}
+
+// Close drops every client and shuts down the server
+func (s *Server) Close() {
+	s.Disconnect()
+	s.Server.Close()
+}
+
+func (s *Server) broadcast(frame interface{}) {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.writeJSON(frame)
+	}
+}
+
+func (s *Server) serveConfig(w http.ResponseWriter, r *http.Request) {
+	gateway := s.GatewayURL()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiUrl": s.URL,
+		"apiKey": "fluxtest",
+		"mobileGatewayUrl": map[string]string{
+			"livetrading": gateway,
+			"papermoney":  gateway + "?papermoney",
+		},
+		"authUrl": s.URL,
+	})
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"scope":        "PlaceTrades AccountAccess MoveMoney",
+		"expires_in":   1800,
+		"token_type":   "Bearer",
+	})
+}
+
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	// the paper trading gateway only accepts paper trading logins
+	c := &conn{ws: ws, platform: "PROD"}
+	if _, paper := r.URL.Query()["papermoney"]; paper {
+		c.platform = "PAPER"
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	// the first message must be the protocol packet
+	var protocol protocolRequest
+	if err := ws.ReadJSON(&protocol); err != nil {
+		return
+	}
+	if protocol.Fmt != "json-patches-structured" {
+		ws.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "unsupported format"))
+		return
+	}
+
+	err = c.writeJSON(protocolResponse{
+		Session: "fluxtest-session",
+		Build:   Build,
+		Ver:     protocol.Ver,
+	})
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	// heartbeat at the interval the client asked for until it disconnects
+	if interval, err := time.ParseDuration(protocol.Heartbeat); err == nil && interval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go s.heartbeats(c, interval, done)
+	}
+
+	for {
+		var load requestLoad
+		if err := ws.ReadJSON(&load); err != nil {
+			return
+		}
+
+		for _, req := range load.Payload {
+			s.serveRequest(c, req)
+		}
+	}
+}
+
+func (s *Server) heartbeats(c *conn, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		s.mu.Lock()
+		silent := s.silent
+		s.mu.Unlock()
+
+		if !silent {
+			c.writeJSON(heartbeat{Heartbeat: time.Now().UnixNano() / int64(time.Millisecond)})
+		}
+	}
+}
+
+func (s *Server) serveRequest(c *conn, req Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	close(s.notify)
+	s.notify = make(chan struct{})
+	responder := s.responders[req.Header.Service]
+	rejectLogin := s.rejectLogin
+	token := s.token
+	s.mu.Unlock()
+
+	if req.Header.Service == "login" {
+		body := loginBody{Authenticated: true, Token: SessionToken}
+
+		var accessToken, platform string
+		req.Param("accessToken", &accessToken)
+		req.Param("platform", &platform)
+		if rejectLogin != "" {
+			body = loginBody{Message: rejectLogin}
+		} else if accessToken != token {
+			body = loginBody{Message: "invalid access token"}
+		} else if platform != c.platform {
+			body = loginBody{Message: "invalid platform"}
+		}
+
+		c.writeJSON(responseLoad{
+			Payload: []response{
+				{Header: req.Header, Body: body},
+			},
+		})
+		return
+	}
+
+	if responder == nil {
+		return
+	}
+
+	patches := responder(req)
+	if len(patches) == 0 {
+		return
+	}
+
+	c.writeJSON(responseLoad{
+		Payload: []response{
+			{Header: req.Header, Body: responseBody{Patches: patches}},
+		},
+	})
+}
@@ -0,0 +1,241 @@
+package fluxtest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux"
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func newSession(t *testing.T, srv *fluxtest.Server, opts ...flux.Option) *flux.Session {
+	t.Helper()
+
+	opts = append([]flux.Option{
+		flux.WithConfigURL(srv.ConfigURL()),
+		flux.WithLogger(nil),
+	}, opts...)
+
+	s, err := flux.New(srv.TdaSession(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHandshake(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newSession(t, srv)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	info := s.ServerInfo()
+	if info.Build != fluxtest.Build || info.Gateway != srv.GatewayURL() {
+		t.Fatalf("unexpected server info %+v", info)
+	}
+	if srv.Connections() != 1 {
+		t.Fatalf("expected 1 connection, got %d", srv.Connections())
+	}
+}
+
+func TestRejectLogin(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.RejectLogin("refresh token expired")
+
+	s := newSession(t, srv, flux.WithReconnectPolicy(flux.ReconnectPolicy{MaxAttempts: 1}))
+	if err := s.Open(); !errors.Is(err, flux.ErrAuthenticationUnsuccessful) {
+		t.Fatalf("expected ErrAuthenticationUnsuccessful, got %v", err)
+	}
+}
+
+func TestPaperMoneyPlatform(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newSession(t, srv, flux.WithEnvironment(flux.PaperMoney))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	req, ok := srv.WaitRequest("login", 0, time.Second)
+	var platform string
+	if !ok || !req.Param("platform", &platform) || platform != "PAPER" {
+		t.Fatalf("expected a PAPER login, got %q", platform)
+	}
+}
+
+func TestResponders(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 390.9}))
+	srv.Handle("instrument_search", fluxtest.Search("AAPL"))
+	srv.Handle("optionSeries", fluxtest.OptionSeries("21 AUG 20", "18 SEP 20"))
+	srv.Handle("option_chain/get", fluxtest.OptionChain(map[string][]float64{
+		"21 AUG 20": {390, 400},
+		"18 SEP 20": {400},
+	}))
+	srv.Handle("quotes/options", fluxtest.OptionQuotes(map[string]map[string]interface{}{
+		".AAPL_21 AUG 20_C400": {"BID": 12.5},
+	}))
+
+	s := newSession(t, srv, flux.WithRequestTimeout(2*time.Second))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	chart, err := s.RequestChart(flux.ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"})
+	if err != nil || chart.Candles.Closes[0] != 390.9 {
+		t.Fatalf("chart: %+v, %v", chart, err)
+	}
+
+	search, err := s.RequestSearch(flux.SearchRequestSignature{Pattern: "AAPL"})
+	if err != nil || len(search.Instruments) != 1 {
+		t.Fatalf("search: %+v, %v", search, err)
+	}
+
+	series, err := s.RequestOptionSeries(flux.OptionSeriesRequestSignature{Ticker: "AAPL"})
+	if err != nil || len(*series) != 2 || (*series)[0].Underlying != "AAPL" {
+		t.Fatalf("option series: %+v, %v", series, err)
+	}
+
+	chain, err := s.RequestOptionChainGet(flux.OptionChainGetRequestSignature{
+		Underlying: "AAPL",
+		Filter:     flux.OptionChainGetFilter{SeriesNames: []string{"21 AUG 20"}},
+	})
+	if err != nil || len(*chain) != 1 || len((*chain)[0].OptionPairs) != 2 {
+		t.Fatalf("option chain: %+v, %v", chain, err)
+	}
+
+	quotes, err := s.RequestOptionQuote(flux.OptionQuoteRequestSignature{Underlying: "AAPL", Fields: []flux.QuoteField{flux.Bid}})
+	if err != nil || len(quotes.Items) != 1 || quotes.Items[0].Values.BID != 12.5 {
+		t.Fatalf("option quotes: %+v, %v", quotes, err)
+	}
+}
+
+func TestErrorPatch(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Reply(fluxtest.Error(404, "symbol not found")))
+
+	s := newSession(t, srv)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err := s.RequestChart(flux.ChartRequestSignature{Ticker: "BADSYM", Range: "DAY1", Width: "HOUR1"})
+	var serviceErr *flux.ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Message != "symbol not found" {
+		t.Fatalf("expected a ServiceError, got %v", err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 390.9}))
+
+	s := newSession(t, srv)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	updates, cancel := s.SubscribeChart(flux.ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"})
+	defer cancel()
+
+	req, ok := srv.WaitRequest("chart_v27", 0, time.Second)
+	if !ok {
+		t.Fatal("the chart was not requested")
+	}
+	// let the snapshot arrive before it is patched
+	time.Sleep(100 * time.Millisecond)
+
+	srv.Push(req.Header,
+		fluxtest.Add("/candles/timestamps/-", 1595264400000),
+		fluxtest.Add("/candles/opens/-", 391),
+		fluxtest.Add("/candles/highs/-", 392),
+		fluxtest.Add("/candles/lows/-", 390),
+		fluxtest.Add("/candles/closes/-", 391.5),
+		fluxtest.Add("/candles/volumes/-", 100),
+	)
+
+	for {
+		select {
+		case update := <-updates:
+			if update.New && update.Close == 391.5 {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the pushed candle was not received")
+		}
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newSession(t, srv, flux.WithHeartbeat(20*time.Millisecond, 100))
+	events := s.Events()
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	srv.SetHeartbeats(false)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if _, ok := e.(flux.HeartbeatMissed); ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no heartbeat was missed")
+		}
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newSession(t, srv, flux.WithReconnectPolicy(flux.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		Multiplier:   1,
+	}))
+	events := s.Events()
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	srv.Disconnect()
+
+	disconnected := false
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case flux.Disconnected:
+				disconnected = true
+			case flux.LoggedIn:
+				if disconnected {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatal("the session did not reconnect")
+		}
+	}
+}
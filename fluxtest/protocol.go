@@ -0,0 +1,109 @@
+package fluxtest
+
+import "encoding/json"
+
+// Header is the header of a request or response on the provisioner socket
+type Header struct {
+	Service string `json:"service"`
+	ID      string `json:"id"`
+	Ver     int    `json:"ver"`
+}
+
+// Request is a single request received from a client, the params are kept as
+// raw JSON so that any service can be scripted
+type Request struct {
+	Header Header          `json:"header"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Param unmarshals a single parameter of the request into v, it returns false
+// if the parameter is not present
+func (r *Request) Param(name string, v interface{}) bool {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return false
+	}
+
+	raw, ok := params[name]
+	if !ok {
+		return false
+	}
+
+	return json.Unmarshal(raw, v) == nil
+}
+
+// Patch is a single json-patch operation (RFC 6902) sent to the client
+type Patch struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Replace returns a patch replacing the value at path
+func Replace(path string, value interface{}) Patch {
+	return Patch{Op: "replace", Path: path, Value: value}
+}
+
+// Add returns a patch adding the value at path
+func Add(path string, value interface{}) Patch {
+	return Patch{Op: "add", Path: path, Value: value}
+}
+
+// Snapshot returns the patch that replaces an entire document, this is the
+// first patch the provisioner sends in response to a request
+func Snapshot(value interface{}) Patch {
+	return Replace("", value)
+}
+
+// Error returns an /error patch, which the provisioner sends when it could
+// not fulfill a request (i.e an invalid symbol)
+func Error(code int, message string) Patch {
+	return Patch{
+		Op:   "add",
+		Path: "/error",
+		Value: map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	}
+}
+
+type protocolRequest struct {
+	Ver       string `json:"ver"`
+	Fmt       string `json:"fmt"`
+	Heartbeat string `json:"heartbeat"`
+}
+
+type protocolResponse struct {
+	Session string `json:"session"`
+	Build   string `json:"build"`
+	Ver     string `json:"ver"`
+}
+
+type requestLoad struct {
+	Payload []Request `json:"payload"`
+}
+
+type responseBody struct {
+	Patches []Patch `json:"patches"`
+}
+
+type response struct {
+	Header   Header      `json:"header"`
+	Revision int         `json:"revision"`
+	Body     interface{} `json:"body"`
+}
+
+type responseLoad struct {
+	Payload []response `json:"payload"`
+}
+
+type loginBody struct {
+	Authenticated bool   `json:"authenticated"`
+	Token         string `json:"token,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+type heartbeat struct {
+	Heartbeat int64 `json:"heartbeat"`
+}
@@ -0,0 +1,213 @@
+package fluxtest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Candle is a single candle in a scripted chart_v27 response
+type Candle struct {
+	Timestamp int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
+}
+
+// Chart returns a Responder for the chart_v27 service which replies with a
+// snapshot of the candles for whichever symbol was requested
+func Chart(candles ...Candle) Responder {
+	return func(req Request) []Patch {
+		var symbol string
+		req.Param("symbol", &symbol)
+		return []Patch{Snapshot(ChartValue(symbol, candles...))}
+	}
+}
+
+// ChartValue is the document the chart_v27 service holds for a symbol
+func ChartValue(symbol string, candles ...Candle) map[string]interface{} {
+	timestamps := []int64{}
+	opens, highs, lows, closes, volumes := []float64{}, []float64{}, []float64{}, []float64{}, []float64{}
+	for _, c := range candles {
+		timestamps = append(timestamps, c.Timestamp)
+		opens = append(opens, c.Open)
+		highs = append(highs, c.High)
+		lows = append(lows, c.Low)
+		closes = append(closes, c.Close)
+		volumes = append(volumes, c.Volume)
+	}
+
+	return map[string]interface{}{
+		"symbol": symbol,
+		"candles": map[string]interface{}{
+			"timestamps": timestamps,
+			"opens":      opens,
+			"highs":      highs,
+			"lows":       lows,
+			"closes":     closes,
+			"volumes":    volumes,
+		},
+	}
+}
+
+// Quotes returns a Responder for the quotes service which replies with a
+// snapshot of the values (keyed by symbol then field) for the symbols that
+// were requested, symbols without values are sent with empty values
+func Quotes(values map[string]map[string]interface{}) Responder {
+	return func(req Request) []Patch {
+		var symbols []string
+		req.Param("symbols", &symbols)
+
+		items := []map[string]interface{}{}
+		for _, symbol := range symbols {
+			v := values[symbol]
+			if v == nil {
+				v = map[string]interface{}{}
+			}
+			items = append(items, map[string]interface{}{
+				"symbol": symbol,
+				"values": v,
+			})
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{"items": items})}
+	}
+}
+
+// Search returns a Responder for the instrument_search service which replies
+// with an instrument per symbol
+func Search(symbols ...string) Responder {
+	return func(req Request) []Patch {
+		instruments := []map[string]interface{}{}
+		for _, symbol := range symbols {
+			instruments = append(instruments, map[string]interface{}{
+				"symbol":        symbol,
+				"displaySymbol": symbol,
+				"rootSymbol":    symbol,
+			})
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{"instruments": instruments})}
+	}
+}
+
+// InstrumentDetails returns a Responder for the instrument_details service
+// which replies with the instrument (keyed by symbol) and whichever of its
+// values (keyed by symbol then field) were requested
+func InstrumentDetails(instruments, values map[string]map[string]interface{}) Responder {
+	return func(req Request) []Patch {
+		var symbol string
+		var fields []string
+		req.Param("symbol", &symbol)
+		req.Param("fields", &fields)
+
+		instrument := instruments[symbol]
+		if instrument == nil {
+			instrument = map[string]interface{}{}
+		}
+		requested := map[string]interface{}{}
+		for _, field := range fields {
+			if v, ok := values[symbol][field]; ok {
+				requested[field] = v
+			}
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{
+			"instrument": instrument,
+			"values":     requested,
+		})}
+	}
+}
+
+// OptionSeries returns a Responder for the optionSeries service which replies
+// with a series of 100 share contracts per name for whichever underlying was
+// requested
+func OptionSeries(names ...string) Responder {
+	return func(req Request) []Patch {
+		var underlying string
+		req.Param("underlying", &underlying)
+
+		series := []map[string]interface{}{}
+		for _, name := range names {
+			series = append(series, map[string]interface{}{
+				"underlying": underlying,
+				"name":       name,
+				"spc":        100,
+				"multiplier": 100,
+			})
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{"series": series})}
+	}
+}
+
+// OptionChain returns a Responder for the option_chain/get service which
+// replies with the strikes of each series (keyed by series name) for whichever
+// underlying was requested, limited to the series named in the request's
+// filter if it names any. Option symbols are formatted as
+// .UNDERLYING_SERIES_C|P<strike>
+func OptionChain(strikes map[string][]float64) Responder {
+	return func(req Request) []Patch {
+		var underlying string
+		var filter struct {
+			SeriesNames []string `json:"seriesNames"`
+		}
+		req.Param("underlyingSymbol", &underlying)
+		req.Param("filter", &filter)
+
+		names := filter.SeriesNames
+		if len(names) == 0 {
+			for name := range strikes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		series := []map[string]interface{}{}
+		for _, name := range names {
+			pairs := []map[string]interface{}{}
+			for _, strike := range strikes[name] {
+				call := fmt.Sprintf(".%s_%s_C%g", underlying, name, strike)
+				put := fmt.Sprintf(".%s_%s_P%g", underlying, name, strike)
+				pairs = append(pairs, map[string]interface{}{
+					"strike":            strike,
+					"callSymbol":        call,
+					"putSymbol":         put,
+					"callDisplaySymbol": call,
+					"putDisplaySymbol":  put,
+				})
+			}
+			series = append(series, map[string]interface{}{
+				"name":        name,
+				"spc":         100,
+				"optionPairs": pairs,
+			})
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{"optionSeries": series})}
+	}
+}
+
+// OptionQuotes returns a Responder for the quotes/options service which
+// replies with the values (keyed by option symbol then field) of every option
+// given, sorted by symbol
+func OptionQuotes(values map[string]map[string]interface{}) Responder {
+	return func(req Request) []Patch {
+		symbols := []string{}
+		for symbol := range values {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+
+		items := []map[string]interface{}{}
+		for _, symbol := range symbols {
+			items = append(items, map[string]interface{}{
+				"symbol": symbol,
+				"values": values[symbol],
+			})
+		}
+
+		return []Patch{Snapshot(map[string]interface{}{"items": items})}
+	}
+}
@@ -0,0 +1,57 @@
+package flux
+
+import "time"
+
+// alive records that a frame arrived from the server, any frame (not only a
+// heartbeat) shows that the connection is still up
+func (s *Session) alive() {
+	s.connMu.Lock()
+	s.lastHeartbeat = time.Now()
+	s.connMu.Unlock()
+}
+
+// watchHeartbeats checks every heartbeat interval that the server is still
+// sending heartbeats (or anything else), once HeartbeatTolerance intervals
+// pass without a frame the connection is closed so that the listen loop reconnects. A half-open
+// connection would otherwise leave the session waiting on data forever
+func (s *Session) watchHeartbeats(conn Conn, done <-chan struct{}) {
+	interval := s.heartbeatInterval
+	if interval <= 0 {
+		return
+	}
+
+	tolerance := s.heartbeatTolerance
+	if tolerance < 1 {
+		tolerance = 1
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		s.connMu.Lock()
+		last := s.lastHeartbeat
+		s.connMu.Unlock()
+
+		// half an interval of grace, so a heartbeat that is only slightly late
+		// does not count as missed
+		missed := int((time.Since(last) - interval/2) / interval)
+		if missed < 1 {
+			continue
+		}
+
+		s.emit(HeartbeatMissed{Last: last, Missed: missed})
+
+		if missed >= tolerance {
+			s.logger.Warn("no heartbeat, dropping the connection", "last", last, "missed", missed)
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package flux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestHeartbeatWatchdogReconnects(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithHeartbeat(20*time.Millisecond, 2))
+	defer s.Close()
+	events := s.Events()
+
+	// the connection stays open but goes quiet
+	srv.SetHeartbeats(false)
+
+	missed, disconnected := false, false
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case HeartbeatMissed:
+				missed = true
+			case Disconnected:
+				if !missed {
+					t.Fatal("disconnected before a heartbeat was missed")
+				}
+				disconnected = true
+			case LoggedIn:
+				if disconnected {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("the session was not reconnected (missed %t, disconnected %t)", missed, disconnected)
+		}
+	}
+}
+
+func TestHeartbeatAnyFrame(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithHeartbeat(50*time.Millisecond, 2))
+	defer s.Close()
+	events := s.Events()
+
+	// heartbeats in shapes other than the one fluxtest sends still count
+	srv.SetHeartbeats(false)
+	frames := []interface{}{
+		map[string]interface{}{"heartbeat": "1595260800000"},
+		map[string]interface{}{"heartbeat": 1595260800000.5},
+		map[string]interface{}{"payload": []interface{}{}},
+	}
+	for i := 0; i < 20; i++ {
+		srv.Send(frames[i%len(frames)])
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case HeartbeatMissed, Disconnected:
+				t.Fatalf("unexpected %T while frames were arriving", e)
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+const (
+	// PriceTick field (instrument details only)
+	PriceTick = QuoteField("PRICE_TICK")
+	// PriceTickValue field (instrument details only)
+	PriceTickValue = QuoteField("PRICE_TICK_VALUE")
+	// DivFrequency field (instrument details only)
+	DivFrequency = QuoteField("DIV_FREQUENCY")
+)
+
+// InstrumentDetailsFields are all of the fields the instrument_details service
+// provides, every request asks for all of them so that the details held for a
+// symbol answer any later request
+var InstrumentDetailsFields = []QuoteField{
+	DivAmount, EPS, ExdDivDate, PriceTick, PriceTickValue, Yield, DivFrequency,
+}
+
+// InstrumentDetailsRequestSignature is the parameter for an instrument details
+// request
+type InstrumentDetailsRequestSignature struct {
+	// ticker of the instrument
+	Ticker string
+}
+
+// shortname presented as INSTRUMENT#TICKER
+func (r *InstrumentDetailsRequestSignature) shortName() string {
+	return fmt.Sprintf("INSTRUMENT#%s", r.Ticker)
+}
+
+// Instrument describes a tradeable symbol
+type Instrument struct {
+	Symbol            string `json:"symbol"`
+	RootSymbol        string `json:"rootSymbol"`
+	DisplaySymbol     string `json:"displaySymbol"`
+	RootDisplaySymbol string `json:"rootDisplaySymbol"`
+	Description       string `json:"description"`
+	InstrumentType    string `json:"instrumentType"`
+	Cusip             string `json:"cusip,omitempty"`
+	ID                int    `json:"id"`
+	Industry          int    `json:"industry"`
+
+	// SourceType is the exchange the instrument is listed on
+	SourceType string `json:"sourceType"`
+
+	// Multiplier is the value of a point of the instrument, Spc is the
+	// number of shares per contract
+	Multiplier     float64 `json:"multiplier"`
+	Spc            float64 `json:"spc"`
+	PriceTick      float64 `json:"priceTick"`
+	PriceTickValue float64 `json:"priceTickValue"`
+	FractionalType string  `json:"fractionalType"`
+
+	FutureOption           bool   `json:"futureOption"`
+	IsFutureProduct        bool   `json:"isFutureProduct"`
+	HasOptions             bool   `json:"hasOptions"`
+	HasTradableOptions     bool   `json:"hasTradableOptions"`
+	SpreadsSupported       bool   `json:"spreadsSupported"`
+	Tradeable              bool   `json:"tradeable"`
+	Composite              bool   `json:"composite"`
+	ExtoEnabled            bool   `json:"extoEnabled"`
+	DaysToExpiration       int    `json:"daysToExpiration"`
+	SpreadDaysToExpiration string `json:"spreadDaysToExpiration"`
+	Flags                  int    `json:"flags"`
+}
+
+// InstrumentDetailsStoredCache is the response for an instrument details
+// request
+type InstrumentDetailsStoredCache struct {
+	Instrument Instrument              `json:"instrument"`
+	Values     InstrumentDetailsValues `json:"values"`
+	Service    string                  `json:"service"`
+	RequestID  string                  `json:"requestId"`
+	RequestVer int                     `json:"requestVer"`
+}
+
+// InstrumentDetailsValues are the fundamentals of an instrument, fields that
+// the provisioner did not populate are zero
+type InstrumentDetailsValues struct {
+	DivAmount      float64 `json:"DIV_AMOUNT,omitempty"`
+	EPS            float64 `json:"EPS,omitempty"`
+	PriceTick      float64 `json:"PRICE_TICK,omitempty"`
+	PriceTickValue float64 `json:"PRICE_TICK_VALUE,omitempty"`
+	Yield          float64 `json:"YIELD,omitempty"`
+	DivFrequency   float64 `json:"DIV_FREQUENCY,omitempty"`
+
+	// ExdDivDate is the ex-dividend date in milliseconds
+	ExdDivDate int64 `json:"EXD_DIV_DATE,omitempty"`
+}
+
+// Exchange returns the exchange the instrument is listed on
+func (d *InstrumentDetailsStoredCache) Exchange() string {
+	return d.Instrument.SourceType
+}
+
+func (s *Session) instrumentDetailsHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+	if _, ok := s.state.apply(key, gab); !ok {
+		return
+	}
+
+	var state InstrumentDetailsStoredCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
+	state.Service = key.Service
+
+	s.router.deliver(key, storedCache{InstrumentDetails: state})
+}
+
+// cachedInstrumentDetails returns the details held for the latest request made
+// for a symbol, details rarely change so they are kept until the session is
+// reset
+func (s *Session) cachedInstrumentDetails(spec InstrumentDetailsRequestSignature) (*InstrumentDetailsStoredCache, bool) {
+	ver, ok := s.versions.last(spec.shortName())
+	if !ok {
+		return nil, false
+	}
+
+	key := stateKey{
+		Service: "instrument_details",
+		ID:      fmt.Sprintf("%s-%d", spec.shortName(), ver),
+	}
+
+	var details InstrumentDetailsStoredCache
+	if !s.state.get(key, &details) || details.Instrument.Symbol == "" {
+		return nil, false
+	}
+	details.RequestID = key.ID
+	details.Service = key.Service
+
+	return &details, true
+}
+
+// RequestInstrumentDetails returns the description, exchange and fundamentals
+// of an instrument, details are cached per symbol so only the first request
+// for a symbol waits on the provisioner
+func (s *Session) RequestInstrumentDetails(spec InstrumentDetailsRequestSignature) (*InstrumentDetailsStoredCache, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestInstrumentDetailsContext(ctx, spec)
+}
+
+// RequestInstrumentDetailsContext is the same as RequestInstrumentDetails but
+// honours the deadline and cancellation of ctx, a deadline that passes is
+// reported as ErrNotReceivedInTime
+func (s *Session) RequestInstrumentDetailsContext(ctx context.Context, spec InstrumentDetailsRequestSignature) (details *InstrumentDetailsStoredCache, err error) {
+	defer s.observeRequest("RequestInstrumentDetails", time.Now(), &err)
+
+	// force capitalization of tickers, since the socket is case sensitive
+	spec.Ticker = strings.ToUpper(spec.Ticker)
+
+	if details, ok := s.cachedInstrumentDetails(spec); ok {
+		return details, nil
+	}
+
+	ver := s.versions.next(spec.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), ver)
+
+	payload := gatewayRequestLoad{
+		Payload: []gatewayRequest{
+			{
+				Header: gatewayHeader{
+					Service: "instrument_details",
+					ID:      uniqueID,
+					Ver:     ver,
+				},
+				Params: gatewayParams{
+					Symbol:      spec.Ticker,
+					QuoteFields: InstrumentDetailsFields,
+				},
+			},
+		},
+	}
+
+	key := stateKey{Service: "instrument_details", ID: uniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
+
+	select {
+
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
+		return &recvPayload.InstrumentDetails, nil
+
+	case <-ctx.Done():
+		return nil, s.requestFailed(ctx, key, "symbol", spec.Ticker)
+
+	}
+}
@@ -0,0 +1,54 @@
+package flux
+
+import (
+	"testing"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestInstrumentDetailsCachedPerSymbol(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	srv.Handle("instrument_details", fluxtest.InstrumentDetails(
+		map[string]map[string]interface{}{
+			"AAPL": {"symbol": "AAPL", "description": "APPLE INC COM"},
+			"MSFT": {"symbol": "MSFT", "description": "MICROSOFT CORP COM"},
+		},
+		map[string]map[string]interface{}{
+			"AAPL": {"EPS": 12.79, "YIELD": 0.77},
+			"MSFT": {"EPS": 5.76},
+		},
+	))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	for _, ticker := range []string{"AAPL", "aapl", "MSFT", "AAPL"} {
+		if _, err := s.RequestInstrumentDetails(InstrumentDetailsRequestSignature{Ticker: ticker}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := srv.Requests("instrument_details")
+	if len(requests) != 2 {
+		t.Fatalf("expected a request per symbol, got %d", len(requests))
+	}
+	for _, req := range requests {
+		var fields []QuoteField
+		req.Param("fields", &fields)
+		if len(fields) != len(InstrumentDetailsFields) {
+			t.Fatalf("expected every field to be requested, got %v", fields)
+		}
+	}
+
+	// every field was requested the first time, so the cached details have
+	// all of the values the provisioner sent
+	details, err := s.RequestInstrumentDetails(InstrumentDetailsRequestSignature{Ticker: "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Instrument.Description != "APPLE INC COM" || details.Values.EPS != 12.79 || details.Values.Yield != 0.77 {
+		t.Fatalf("unexpected details %+v", details)
+	}
+}
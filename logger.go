@@ -0,0 +1,76 @@
+package flux
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the messages a Session logs, each with a level and a list
+// of alternating keys and values (such as "service", "chart_v27"). Pass one to
+// New with WithLogger, *slog.Logger can be adapted with NewSlogLogger
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NewStdLogger returns a Logger that writes single lines of text to l (or the
+// standard library's default logger if l is nil), debug messages are only
+// written if debug is true
+func NewStdLogger(l *log.Logger, debug bool) Logger {
+	return stdLogger{logger: l, debug: debug}
+}
+
+// stdLogger writes lines like `[FLUX] INFO message key=value` through a
+// *log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	debug  bool
+}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if l.debug {
+		l.log("DEBUG", msg, keyvals)
+	}
+}
+
+func (l stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log("INFO", msg, keyvals)
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log("WARN", msg, keyvals)
+}
+
+func (l stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log("ERROR", msg, keyvals)
+}
+
+func (l stdLogger) log(level, msg string, keyvals []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[FLUX] %s %s", level, msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keyvals[i])
+		}
+	}
+
+	if l.logger != nil {
+		l.logger.Println(b.String())
+	} else {
+		log.Println(b.String())
+	}
+}
+
+// nopLogger discards everything
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
@@ -0,0 +1,48 @@
+package flux
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug bool
+		log   func(l Logger)
+		want  string
+	}{
+		{"info", false, func(l Logger) { l.Info("logged in") }, "[FLUX] INFO logged in\n"},
+		{"keyvals", false, func(l Logger) { l.Warn("reconnecting", "attempt", 2, "delay", "1s") }, "[FLUX] WARN reconnecting attempt=2 delay=1s\n"},
+		{"odd keyvals", false, func(l Logger) { l.Error("login failed", "reason", "expired", "dangling") }, "[FLUX] ERROR login failed reason=expired dangling\n"},
+		{"debug off", false, func(l Logger) { l.Debug("received", "frame", "{}") }, ""},
+		{"debug on", true, func(l Logger) { l.Debug("received", "frame", "{}") }, "[FLUX] DEBUG received frame={}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(NewStdLogger(log.New(&buf, "", 0), tt.debug))
+			if buf.String() != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestStdLoggerDefault(t *testing.T) {
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	}()
+
+	NewStdLogger(nil, false).Info("logged in", "gateway", "wss://example")
+	if want := "[FLUX] INFO logged in gateway=wss://example\n"; buf.String() != want {
+		t.Fatalf("expected %q, got %q", want, buf.String())
+	}
+}
@@ -0,0 +1,108 @@
+package flux
+
+import "time"
+
+// MarketSession is the part of the trading day a candle falls in, by its
+// opening time in US/Eastern
+type MarketSession int
+
+const (
+	// PreMarket is anything before the 9:30 open
+	PreMarket MarketSession = iota
+	// RegularHours is from the 9:30 open to the 16:00 close
+	RegularHours
+	// AfterHours is anything from the 16:00 close on
+	AfterHours
+)
+
+// String returns the name of the market session
+func (m MarketSession) String() string {
+	switch m {
+	case PreMarket:
+		return "pre-market"
+	case RegularHours:
+		return "regular"
+	case AfterHours:
+		return "after-hours"
+	}
+	return "unknown"
+}
+
+const (
+	marketOpen  = 9*time.Hour + 30*time.Minute
+	marketClose = 16 * time.Hour
+)
+
+// eastern is nil when the time zone database is not available, US/Eastern is
+// then worked out from the daylight saving rules instead
+var eastern, _ = time.LoadLocation("America/New_York")
+
+// easternTime returns t in US/Eastern
+func easternTime(t time.Time) time.Time {
+	if eastern != nil {
+		return t.In(eastern)
+	}
+
+	// daylight saving runs from 2:00 on the second Sunday of March to 2:00 on
+	// the first Sunday of November (both local time)
+	utc := t.UTC()
+	start := nthSunday(utc.Year(), time.March, 2).Add(2*time.Hour + 5*time.Hour)
+	end := nthSunday(utc.Year(), time.November, 1).Add(2*time.Hour + 4*time.Hour)
+	if !utc.Before(start) && utc.Before(end) {
+		return utc.In(time.FixedZone("EDT", -4*60*60))
+	}
+	return utc.In(time.FixedZone("EST", -5*60*60))
+}
+
+// nthSunday returns midnight (UTC) of the n-th Sunday of a month
+func nthSunday(year int, month time.Month, n int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	offset := (7 - int(first.Weekday())) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// Session returns the market session the candle opened in, this is only
+// meaningful for intraday candles
+func (c Candle) Session() MarketSession {
+	t := easternTime(c.Time())
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	switch {
+	case sinceMidnight < marketOpen:
+		return PreMarket
+	case sinceMidnight < marketClose:
+		return RegularHours
+	}
+	return AfterHours
+}
+
+// Sessions returns the market session of every candle of the chart
+func (c *ChartStoredCache) Sessions() []MarketSession {
+	sessions := []MarketSession{}
+	for i := range c.Candles.Timestamps {
+		sessions = append(sessions, c.Candle(i).Session())
+	}
+	return sessions
+}
+
+// FilterSessions returns the candles of the chart that opened in any of the
+// given market sessions, in order
+func (c *ChartStoredCache) FilterSessions(sessions ...MarketSession) []Candle {
+	candles := []Candle{}
+	for i := range c.Candles.Timestamps {
+		candle := c.Candle(i)
+		for _, session := range sessions {
+			if candle.Session() == session {
+				candles = append(candles, candle)
+				break
+			}
+		}
+	}
+	return candles
+}
+
+// RegularHoursCandles returns the candles of the chart that opened during
+// regular trading hours
+func (c *ChartStoredCache) RegularHoursCandles() []Candle {
+	return c.FilterSessions(RegularHours)
+}
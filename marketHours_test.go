@@ -0,0 +1,146 @@
+package flux
+
+import (
+	"testing"
+	"time"
+)
+
+// easternMillis returns the timestamp (in milliseconds) of a wall clock time
+// in US/Eastern, offset is the zone's offset from UTC in hours
+func easternMillis(year int, month time.Month, day, hour, min, offset int) int64 {
+	t := time.Date(year, month, day, hour, min, 0, 0, time.FixedZone("", offset*60*60))
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// withoutTZData runs fn with US/Eastern worked out from the daylight saving
+// rules rather than the time zone database
+func withoutTZData(t *testing.T, fn func(t *testing.T)) {
+	loaded := eastern
+	eastern = nil
+	defer func() { eastern = loaded }()
+	fn(t)
+}
+
+func TestCandleSession(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp int64
+		want      MarketSession
+	}{
+		{"EST 9:29", easternMillis(2020, time.January, 15, 9, 29, -5), PreMarket},
+		{"EST 9:30", easternMillis(2020, time.January, 15, 9, 30, -5), RegularHours},
+		{"EST 15:59", easternMillis(2020, time.January, 15, 15, 59, -5), RegularHours},
+		{"EST 16:00", easternMillis(2020, time.January, 15, 16, 0, -5), AfterHours},
+		{"EDT 9:29", easternMillis(2020, time.July, 20, 9, 29, -4), PreMarket},
+		{"EDT 9:30", easternMillis(2020, time.July, 20, 9, 30, -4), RegularHours},
+		{"EDT 15:59", easternMillis(2020, time.July, 20, 15, 59, -4), RegularHours},
+		{"EDT 16:00", easternMillis(2020, time.July, 20, 16, 0, -4), AfterHours},
+
+		// the clocks change at 2:00 on these days, so the session hours are
+		// already in the new offset
+		{"spring forward 9:29", easternMillis(2020, time.March, 8, 9, 29, -4), PreMarket},
+		{"spring forward 9:30", easternMillis(2020, time.March, 8, 9, 30, -4), RegularHours},
+		{"spring forward 15:59", easternMillis(2020, time.March, 8, 15, 59, -4), RegularHours},
+		{"spring forward 16:00", easternMillis(2020, time.March, 8, 16, 0, -4), AfterHours},
+		{"fall back 9:29", easternMillis(2020, time.November, 1, 9, 29, -5), PreMarket},
+		{"fall back 9:30", easternMillis(2020, time.November, 1, 9, 30, -5), RegularHours},
+		{"fall back 15:59", easternMillis(2020, time.November, 1, 15, 59, -5), RegularHours},
+		{"fall back 16:00", easternMillis(2020, time.November, 1, 16, 0, -5), AfterHours},
+
+		// the first trading days after the clocks change
+		{"after spring forward 9:30", easternMillis(2021, time.March, 15, 9, 30, -4), RegularHours},
+		{"after fall back 9:29", easternMillis(2021, time.November, 8, 9, 29, -5), PreMarket},
+	}
+
+	run := func(t *testing.T) {
+		for _, tt := range tests {
+			if got := (Candle{Timestamp: tt.timestamp}).Session(); got != tt.want {
+				t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+			}
+		}
+	}
+
+	t.Run("tzdata", func(t *testing.T) {
+		if eastern == nil {
+			t.Skip("the time zone database is not available")
+		}
+		run(t)
+	})
+	t.Run("fallback", func(t *testing.T) {
+		withoutTZData(t, run)
+	})
+}
+
+func TestEasternTimeFallback(t *testing.T) {
+	if eastern == nil {
+		t.Skip("the time zone database is not available")
+	}
+
+	// every quarter hour of two years, across the daylight saving changes
+	var times []time.Time
+	for tm := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC); tm.Year() < 2022; tm = tm.Add(15 * time.Minute) {
+		times = append(times, tm)
+	}
+
+	want := []time.Time{}
+	for _, tm := range times {
+		want = append(want, easternTime(tm))
+	}
+
+	withoutTZData(t, func(t *testing.T) {
+		for i, tm := range times {
+			got := easternTime(tm)
+			_, gotOffset := got.Zone()
+			_, wantOffset := want[i].Zone()
+			if gotOffset != wantOffset || got.Hour() != want[i].Hour() || got.Minute() != want[i].Minute() {
+				t.Fatalf("%v: expected %v, got %v", tm, want[i], got)
+			}
+		}
+	})
+}
+
+func TestFilterSessions(t *testing.T) {
+	chart := &ChartStoredCache{}
+	chart.Candles.Timestamps = []int64{
+		easternMillis(2020, time.July, 20, 8, 0, -4),
+		easternMillis(2020, time.July, 20, 9, 30, -4),
+		easternMillis(2020, time.July, 20, 12, 0, -4),
+		easternMillis(2020, time.July, 20, 16, 0, -4),
+		easternMillis(2020, time.July, 20, 19, 55, -4),
+	}
+	chart.Candles.Closes = []float64{1, 2, 3, 4, 5}
+
+	sessions := chart.Sessions()
+	wantSessions := []MarketSession{PreMarket, RegularHours, RegularHours, AfterHours, AfterHours}
+	if len(sessions) != len(wantSessions) {
+		t.Fatalf("expected %v, got %v", wantSessions, sessions)
+	}
+	for i := range wantSessions {
+		if sessions[i] != wantSessions[i] {
+			t.Fatalf("expected %v, got %v", wantSessions, sessions)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		candles   []Candle
+		wantClose []float64
+	}{
+		{"regular hours", chart.RegularHoursCandles(), []float64{2, 3}},
+		{"pre-market", chart.FilterSessions(PreMarket), []float64{1}},
+		{"extended hours", chart.FilterSessions(PreMarket, AfterHours), []float64{1, 4, 5}},
+		{"every session", chart.FilterSessions(AfterHours, RegularHours, PreMarket), []float64{1, 2, 3, 4, 5}},
+		{"no sessions", chart.FilterSessions(), nil},
+	}
+
+	for _, tt := range tests {
+		if len(tt.candles) != len(tt.wantClose) {
+			t.Fatalf("%s: expected %d candles, got %+v", tt.name, len(tt.wantClose), tt.candles)
+		}
+		for i, candle := range tt.candles {
+			if candle.Close != tt.wantClose[i] {
+				t.Fatalf("%s: expected the closes %v, got %+v", tt.name, tt.wantClose, tt.candles)
+			}
+		}
+	}
+}
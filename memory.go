@@ -0,0 +1,136 @@
+package flux
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MemoryDialer is an in-memory Dialer, every call to Dial creates a connected
+// pair of MemoryConns and hands the server side of the pair to Accept so that
+// a test can play the part of the gateway without a network
+type MemoryDialer struct {
+	conns chan *MemoryConn
+}
+
+// NewMemoryDialer returns a MemoryDialer ready to be passed to WithDialer
+func NewMemoryDialer() *MemoryDialer {
+	return &MemoryDialer{
+		conns: make(chan *MemoryConn, 16),
+	}
+}
+
+// Dial creates a new connection pair, the url and header are ignored
+func (m *MemoryDialer) Dial(url string, header http.Header) (Conn, error) {
+	client, server := memoryPipe()
+	m.conns <- server
+	return client, nil
+}
+
+// Accept blocks until the session dials and returns the server side of the
+// connection, subsequent dials (i.e reconnects) are returned by later calls
+func (m *MemoryDialer) Accept() *MemoryConn {
+	return <-m.conns
+}
+
+// Conns exposes the server side connections as a channel for use in a select
+func (m *MemoryDialer) Conns() <-chan *MemoryConn {
+	return m.conns
+}
+
+type memoryFrame struct {
+	messageType int
+	data        []byte
+}
+
+// MemoryConn is one side of an in-memory connection, both sides implement Conn
+type MemoryConn struct {
+	in   chan memoryFrame
+	peer *MemoryConn
+	done chan struct{}
+	once *sync.Once
+}
+
+func memoryPipe() (*MemoryConn, *MemoryConn) {
+	done := make(chan struct{})
+	once := &sync.Once{}
+
+	a := &MemoryConn{in: make(chan memoryFrame, 256), done: done, once: once}
+	b := &MemoryConn{in: make(chan memoryFrame, 256), done: done, once: once}
+	a.peer, b.peer = b, a
+
+	return a, b
+}
+
+// ReadMessage blocks until the other side writes a message, a close frame from
+// the other side is returned as a *websocket.CloseError
+func (m *MemoryConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-m.in:
+		if frame.messageType == websocket.CloseMessage {
+			m.Close()
+			return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+		}
+		return frame.messageType, frame.data, nil
+
+	case <-m.done:
+		// drain anything written before the close
+		select {
+		case frame := <-m.in:
+			if frame.messageType != websocket.CloseMessage {
+				return frame.messageType, frame.data, nil
+			}
+		default:
+		}
+		return 0, nil, ErrConnClosed
+	}
+}
+
+// ReadJSON reads the next message and unmarshals it into v
+func (m *MemoryConn) ReadJSON(v interface{}) error {
+	_, data, err := m.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage sends a message to the other side of the connection
+func (m *MemoryConn) WriteMessage(messageType int, data []byte) error {
+	frame := memoryFrame{
+		messageType: messageType,
+		data:        append([]byte(nil), data...),
+	}
+
+	select {
+	case <-m.done:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case m.peer.in <- frame:
+		return nil
+	case <-m.done:
+		return ErrConnClosed
+	}
+}
+
+// WriteJSON marshals v and sends it to the other side of the connection
+func (m *MemoryConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.WriteMessage(websocket.TextMessage, data)
+}
+
+// Close closes both sides of the connection
+func (m *MemoryConn) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	return nil
+}
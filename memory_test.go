@@ -0,0 +1,318 @@
+package flux
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+	"github.com/adityaxdiwakar/tda-go"
+)
+
+// memoryGateway plays the part of the provisioner on the server side of a
+// MemoryDialer, answering the protocol packet, the login and every request
+// with the patches of the service's responder
+type memoryGateway struct {
+	dialer     *MemoryDialer
+	responders map[string]fluxtest.Responder
+}
+
+type memoryRequestLoad struct {
+	Payload []fluxtest.Request `json:"payload"`
+}
+
+type memoryResponse struct {
+	Header fluxtest.Header `json:"header"`
+	Body   interface{}     `json:"body"`
+}
+
+type memoryResponseLoad struct {
+	Payload []memoryResponse `json:"payload"`
+}
+
+func (g *memoryGateway) serve(done <-chan struct{}) {
+	for {
+		select {
+		case conn := <-g.dialer.Conns():
+			go g.serveConn(conn)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (g *memoryGateway) serveConn(conn *MemoryConn) {
+	defer conn.Close()
+
+	var protocol protocolPacketData
+	if err := conn.ReadJSON(&protocol); err != nil {
+		return
+	}
+	conn.WriteJSON(protocolResponse{Session: "memory", Build: "memory", Ver: protocol.Ver})
+
+	for {
+		var load memoryRequestLoad
+		if err := conn.ReadJSON(&load); err != nil {
+			return
+		}
+
+		for _, req := range load.Payload {
+			var body interface{}
+			if req.Header.Service == "login" {
+				body = loginResponse{Authenticated: true, Token: "memory-session"}
+			} else if responder, ok := g.responders[req.Header.Service]; ok {
+				body = map[string]interface{}{"patches": responder(req)}
+			} else {
+				continue
+			}
+
+			conn.WriteJSON(memoryResponseLoad{
+				Payload: []memoryResponse{{Header: req.Header, Body: body}},
+			})
+		}
+	}
+}
+
+// newMemorySession returns an open Session whose connection is served by a
+// memoryGateway, only the access token is fetched over (local) HTTP
+func newMemorySession(t *testing.T, responders map[string]fluxtest.Responder, opts ...Option) *Session {
+	t.Helper()
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "memory-token"})
+	}))
+	t.Cleanup(tokens.Close)
+
+	gateway := &memoryGateway{dialer: NewMemoryDialer(), responders: responders}
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go gateway.serve(done)
+
+	opts = append([]Option{
+		WithDialer(gateway.dialer),
+		WithGatewayURL("memory://gateway"),
+		WithHeartbeat(0, 0),
+		WithRequestTimeout(time.Second),
+		WithLogger(nil),
+	}, opts...)
+
+	s, err := New(tda.Session{RootUrl: tokens.URL}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestMemoryRequestChart(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"chart_v27": fluxtest.Chart(
+			fluxtest.Candle{Timestamp: 1595260800000, Close: 390.9},
+			fluxtest.Candle{Timestamp: 1595264400000, Close: 391.2},
+		),
+	})
+
+	chart, err := s.RequestChart(ChartRequestSignature{Ticker: "aapl", Range: "DAY1", Width: "HOUR1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chart.Symbol != "AAPL" || len(chart.Candles.Closes) != 2 || chart.Candles.Closes[1] != 391.2 {
+		t.Fatalf("unexpected chart %+v", chart)
+	}
+}
+
+func TestMemoryRequestMultipleCharts(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"chart_v27": fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}),
+	})
+
+	charts, errored := s.RequestMultipleCharts([]ChartRequestSignature{
+		{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"},
+		{Ticker: "MSFT", Range: "DAY1", Width: "HOUR1"},
+	})
+	if len(errored) != 0 || len(charts) != 2 {
+		t.Fatalf("got %d charts, %d errored", len(charts), len(errored))
+	}
+	if charts[0].Symbol != "AAPL" || charts[1].Symbol != "MSFT" {
+		t.Fatalf("unexpected symbols %s, %s", charts[0].Symbol, charts[1].Symbol)
+	}
+}
+
+func TestMemoryRequestSearch(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"instrument_search": fluxtest.Search("AAPL", "AAPU"),
+	})
+
+	search, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAP", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(search.Instruments) != 2 || search.Instruments[0].Symbol != "AAPL" {
+		t.Fatalf("unexpected search %+v", search)
+	}
+}
+
+func TestMemoryRequestQuote(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"quotes": fluxtest.Quotes(map[string]map[string]interface{}{
+			"AAPL": {"BID": 390.1, "ASK": 390.2},
+		}),
+	})
+
+	quote, err := s.RequestQuote(QuoteRequestSignature{Ticker: "aapl", Fields: []QuoteField{Bid, Ask}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quote.Items) != 1 || quote.Items[0].Values.BID != 390.1 || quote.Items[0].Values.ASK != 390.2 {
+		t.Fatalf("unexpected quote %+v", quote)
+	}
+}
+
+func TestMemoryRequestOptionSeries(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"optionSeries": fluxtest.OptionSeries("21 AUG 20"),
+	})
+
+	series, err := s.RequestOptionSeries(OptionSeriesRequestSignature{Ticker: "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*series) != 1 || (*series)[0].Name != "21 AUG 20" {
+		t.Fatalf("unexpected series %+v", series)
+	}
+}
+
+func TestMemoryRequestOptionChainGet(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"option_chain/get": fluxtest.OptionChain(map[string][]float64{"21 AUG 20": {400}}),
+	})
+
+	chain, err := s.RequestOptionChainGet(OptionChainGetRequestSignature{Underlying: "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*chain) != 1 || (*chain)[0].OptionPairs[0].CallSymbol != ".AAPL_21 AUG 20_C400" {
+		t.Fatalf("unexpected chain %+v", chain)
+	}
+}
+
+func TestMemoryRequestOptionQuote(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"quotes/options": fluxtest.OptionQuotes(map[string]map[string]interface{}{
+			".AAPL_21 AUG 20_C400": {"BID": 12.5, "ASK": 12.7},
+		}),
+	})
+
+	quote, err := s.RequestOptionQuote(OptionQuoteRequestSignature{Underlying: "AAPL", Fields: []QuoteField{Bid, Ask}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quote.Items) != 1 || quote.Items[0].Values.BID != 12.5 {
+		t.Fatalf("unexpected option quote %+v", quote)
+	}
+}
+
+func TestMemoryRequestInstrumentDetails(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"instrument_details": fluxtest.InstrumentDetails(
+			map[string]map[string]interface{}{"AAPL": {"symbol": "AAPL", "description": "APPLE INC COM", "sourceType": "NASDAQ"}},
+			map[string]map[string]interface{}{"AAPL": {"EPS": 12.79}},
+		),
+	})
+
+	details, err := s.RequestInstrumentDetails(InstrumentDetailsRequestSignature{Ticker: "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Instrument.Description != "APPLE INC COM" || details.Exchange() != "NASDAQ" || details.Values.EPS != 12.79 {
+		t.Fatalf("unexpected details %+v", details)
+	}
+}
+
+func TestMemoryRequestService(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"news": func(req fluxtest.Request) []fluxtest.Patch {
+			var symbol string
+			req.Param("symbol", &symbol)
+			return []fluxtest.Patch{fluxtest.Snapshot(map[string]interface{}{"symbol": symbol})}
+		},
+	})
+	s.RegisterService("news", nil)
+
+	resp, err := s.RequestService("news", map[string]string{"symbol": "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var news struct{ Symbol string }
+	if err := resp.Decode(&news); err != nil || news.Symbol != "AAPL" {
+		t.Fatalf("unexpected document %s (%v)", resp.Document, err)
+	}
+
+	if _, err := s.RequestService("unknown", nil); !errors.Is(err, ErrUnknownService) {
+		t.Fatalf("expected ErrUnknownService, got %v", err)
+	}
+}
+
+func TestMemoryServiceError(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"chart_v27": fluxtest.Reply(fluxtest.Error(404, "symbol not found")),
+	})
+
+	start := time.Now()
+	_, err := s.RequestChart(ChartRequestSignature{Ticker: "BADSYM", Range: "DAY1", Width: "HOUR1"})
+
+	var serviceErr *ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Code != 404 {
+		t.Fatalf("expected a ServiceError, got %v", err)
+	}
+	if time.Since(start) >= s.requestTimeout {
+		t.Fatal("the error was not returned before the timeout")
+	}
+}
+
+func TestMemoryNotReceivedInTime(t *testing.T) {
+	s := newMemorySession(t, nil, WithRequestTimeout(50*time.Millisecond))
+
+	if _, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAPL"}); err != ErrNotReceivedInTime {
+		t.Fatalf("expected ErrNotReceivedInTime, got %v", err)
+	}
+}
+
+func TestMemoryConnClosed(t *testing.T) {
+	s := newMemorySession(t, nil)
+	s.Close()
+
+	if _, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAPL"}); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+}
+
+func TestMemoryOneShotDocumentsRemoved(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"instrument_search": fluxtest.Search("AAPL"),
+		"news":              fluxtest.Reply(fluxtest.Snapshot(map[string]interface{}{})),
+	})
+	s.RegisterService("news", nil)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAPL"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RequestService("news", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	if len(s.state.docs) != 0 {
+		t.Fatalf("%d documents were kept", len(s.state.docs))
+	}
+}
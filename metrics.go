@@ -0,0 +1,44 @@
+package flux
+
+import "time"
+
+// Metrics is told about the traffic and health of a Session, pass one to New
+// with WithMetrics. NewPrometheusMetrics returns an implementation that can be
+// scraped by Prometheus
+type Metrics interface {
+	// FrameReceived is called for every response received from the gateway,
+	// heartbeats are reported with the service "heartbeat"
+	FrameReceived(service string)
+
+	// PatchesApplied is called for every response that patches a document,
+	// failed patches could not be applied and were skipped
+	PatchesApplied(service string, applied, failed int)
+
+	// RequestCompleted is called when a Request method returns, err is
+	// ErrNotReceivedInTime if the request timed out
+	RequestCompleted(method string, latency time.Duration, err error)
+
+	// ReconnectAttempted is called after every reconnect attempt, err is nil
+	// if the attempt succeeded
+	ReconnectAttempted(err error)
+
+	// QueueDepthChanged is called when items are added to (or taken from) a
+	// queue that holds updates until they are read, the queue is "events" or
+	// the service of a subscription
+	QueueDepthChanged(queue string, delta int)
+}
+
+// nopMetrics discards everything
+type nopMetrics struct{}
+
+func (nopMetrics) FrameReceived(service string)                                     {}
+func (nopMetrics) PatchesApplied(service string, applied, failed int)               {}
+func (nopMetrics) RequestCompleted(method string, latency time.Duration, err error) {}
+func (nopMetrics) ReconnectAttempted(err error)                                     {}
+func (nopMetrics) QueueDepthChanged(queue string, delta int)                        {}
+
+// observeRequest reports a finished request to the session's metrics, it is
+// deferred at the start of every Request method
+func (s *Session) observeRequest(method string, start time.Time, err *error) {
+	s.metrics.RequestCompleted(method, time.Since(start), *err)
+}
@@ -2,7 +2,6 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -61,16 +60,21 @@ type OptionChainGetStoredCache struct {
 	RequestVer   int                 `json:"requestVer"`
 }
 
-type optionChainGetWrapper struct {
-	Op    string                    `json:"op"`
-	Path  string                    `json:"path"`
-	Value OptionChainGetStoredCache `json:"value"`
-}
-
 // RequestOptionChainGet requests to get an option chain with the input being the OptionChainGetRequestSignature
 func (s *Session) RequestOptionChainGet(spec OptionChainGetRequestSignature) (*[]OptionChainSeries, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestOptionChainGetContext(ctx, spec)
+}
+
+// RequestOptionChainGetContext is the same as RequestOptionChainGet but honours
+// the deadline and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestOptionChainGetContext(ctx context.Context, spec OptionChainGetRequestSignature) (chain *[]OptionChainSeries, err error) {
+	defer s.observeRequest("RequestOptionChainGet", time.Now(), &err)
 
-	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), s.OptionChainGetRequestVers[spec.shortName()])
+	ver := s.versions.next(spec.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), ver)
 	spec.UniqueID = uniqueID
 
 	payload := gatewayRequestLoad{
@@ -78,7 +82,7 @@ func (s *Session) RequestOptionChainGet(spec OptionChainGetRequestSignature) (*[
 			{
 				Header: gatewayHeader{
 					Service: "option_chain/get",
-					Ver:     int(s.OptionChainGetRequestVers[spec.shortName()]),
+					Ver:     ver,
 					ID:      spec.UniqueID,
 				},
 				Params: gatewayParams{
@@ -89,55 +93,38 @@ func (s *Session) RequestOptionChainGet(spec OptionChainGetRequestSignature) (*[
 		},
 	}
 
-	s.OptionChainGetRequestVers[spec.shortName()]++
-	s.sendJSON(payload)
-
-	internalChannel := make(chan storedCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second)
-	defer ctxCancel()
-
-	go func() {
-		for {
-			select {
-
-			case recvPayload := <-s.TransactionChannel:
-				if recvPayload.OptionChainGet.RequestID == spec.UniqueID {
-					internalChannel <- recvPayload
-					return
-				}
+	key := stateKey{Service: "option_chain/get", ID: spec.UniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+	defer s.state.remove(key)
 
-			case <-ctx.Done():
-				return
-
-			}
-
-		}
-	}()
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
 	select {
 
-	case recvPayload := <-internalChannel:
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
 		return &recvPayload.OptionChainGet.OptionSeries, nil
 
 	case <-ctx.Done():
-		return nil, ErrNotReceivedInTime
+		return nil, s.requestFailed(ctx, key, "symbol", spec.Underlying)
 
 	}
 }
 
-func (s *Session) optionChainGetHandler(msg []byte, patch *gabs.Container) {
-	patchBody := patch.S("body", "patches", "0")
-
-	rID := patch.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
-
-	var state optionChainGetWrapper
-	err := json.Unmarshal(patchBody.Bytes(), &state)
-	if err != nil {
+func (s *Session) optionChainGetHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+	if _, ok := s.state.apply(key, gab); !ok {
 		return
 	}
 
-	state.Value.RequestID = rID
-	s.CurrentState.OptionChainGet = state.Value
-	s.TransactionChannel <- s.CurrentState
+	var state OptionChainGetStoredCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
+
+	s.router.deliver(key, storedCache{OptionChainGet: state})
 }
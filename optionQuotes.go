@@ -2,12 +2,10 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/Jeffail/gabs/v2"
-	jsonpatch "github.com/evanphx/json-patch"
 )
 
 const (
@@ -66,16 +64,23 @@ type OptionQuoteItem struct {
 	} `json:"values"`
 }
 
-type newOptionQuote struct {
-	Op    string           `json:"op"`
-	Path  string           `json:"path"`
-	Value OptionQuoteCache `json:"value"`
+// RequestOptionQuote requests to get an option quote with the spec
+// OptionQuoteRequestSignature, option chains are large so it waits five times
+// the session's request timeout (see WithRequestTimeout)
+func (s *Session) RequestOptionQuote(spec OptionQuoteRequestSignature) (*OptionQuoteCache, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestOptionQuoteContext(ctx, spec)
 }
 
-// RequestOptionQuote requests to get an option quote with the spec OptionQuoteRequestSignature
-func (s *Session) RequestOptionQuote(spec OptionQuoteRequestSignature) (*OptionQuoteCache, error) {
+// RequestOptionQuoteContext is the same as RequestOptionQuote but honours the
+// deadline and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestOptionQuoteContext(ctx context.Context, spec OptionQuoteRequestSignature) (quote *OptionQuoteCache, err error) {
+	defer s.observeRequest("RequestOptionQuote", time.Now(), &err)
 
-	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), s.OptionQuoteRequestVers[spec.shortName()])
+	ver := s.versions.next(spec.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), ver)
 	spec.UniqueID = uniqueID
 
 	payload := gatewayRequestLoad{
@@ -83,7 +88,7 @@ func (s *Session) RequestOptionQuote(spec OptionQuoteRequestSignature) (*OptionQ
 			{
 				Header: gatewayHeader{
 					Service: "quotes/options",
-					Ver:     int(s.OptionQuoteRequestVers[spec.shortName()]),
+					Ver:     ver,
 					ID:      spec.UniqueID,
 				},
 				Params: gatewayParams{
@@ -96,73 +101,45 @@ func (s *Session) RequestOptionQuote(spec OptionQuoteRequestSignature) (*OptionQ
 		},
 	}
 
-	s.OptionQuoteRequestVers[spec.shortName()]++
-	s.sendJSON(payload)
-
-	internalChannel := make(chan storedCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer ctxCancel()
+	key := stateKey{Service: "quotes/options", ID: spec.UniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+	defer s.state.remove(key)
 
-	go func() {
-		for {
-			select {
-
-			case recvPayload := <-s.TransactionChannel:
-				if recvPayload.OptionQuote.RequestID == spec.UniqueID {
-					time.Sleep(1000 * time.Millisecond)
-					internalChannel <- s.CurrentState
-					return
-				}
-
-			case <-ctx.Done():
-				return
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
-			}
+	select {
 
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
 		}
-	}()
 
-	select {
+		// the quotes are patched in shortly after the first response
+		settle(ctx, 1000*time.Millisecond)
 
-	case recvPayload := <-internalChannel:
-		return &recvPayload.OptionQuote, nil
+		var quote OptionQuoteCache
+		s.state.get(key, &quote)
+		quote.RequestID = key.ID
+		return &quote, nil
 
 	case <-ctx.Done():
-		return nil, ErrNotReceivedInTime
+		return nil, s.requestFailed(ctx, key, "symbol", spec.Underlying)
 
 	}
 }
 
-func (s *Session) optionQuoteHandler(msg []byte, patch *gabs.Container) {
-
-	rID := patch.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
-	patch = patch.S("body", "patches", "0")
-
-	newState := s.CurrentState
-	path := patch.S("path").String()
-	if path == "\"\"" {
-		newState.OptionQuote = OptionQuoteCache{}
-	}
-	patch.Set(fmt.Sprintf("/optionQuote%s", path[1:len(path)-1]), "path")
-
-	bytesJSON, _ := patch.MarshalJSON()
-	patchStr := "[" + string(bytesJSON) + "]"
-	jspatch, err := jsonpatch.DecodePatch([]byte(patchStr))
-
-	if err != nil {
+func (s *Session) optionQuoteHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+	if _, ok := s.state.apply(key, gab); !ok {
 		return
 	}
 
-	byteState, _ := json.Marshal(newState)
-
-	byteState, err = jspatch.Apply(byteState)
-	if err != nil {
-		return
-	}
+	var state OptionQuoteCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
 
-	json.Unmarshal(byteState, &newState)
-	newState.OptionQuote.RequestID = rID
-	s.CurrentState = newState
-	s.TransactionChannel <- s.CurrentState
+	s.router.deliver(key, storedCache{OptionQuote: state})
 }
@@ -2,7 +2,6 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -43,16 +42,21 @@ type OptionSeriesCache struct {
 	RequestVer int            `json:"requestVer"`
 }
 
-type newOptionSeries struct {
-	Op    string            `json:"op"`
-	Path  string            `json:"path"`
-	Value OptionSeriesCache `json:"value"`
-}
-
 // RequestOptionSeries returns options series data for a specific series based on the spec provided
 func (s *Session) RequestOptionSeries(spec OptionSeriesRequestSignature) (*[]OptionSeries, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestOptionSeriesContext(ctx, spec)
+}
 
-	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), s.OptionSeriesRequestVers[spec.shortName()])
+// RequestOptionSeriesContext is the same as RequestOptionSeries but honours the
+// deadline and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestOptionSeriesContext(ctx context.Context, spec OptionSeriesRequestSignature) (series *[]OptionSeries, err error) {
+	defer s.observeRequest("RequestOptionSeries", time.Now(), &err)
+
+	ver := s.versions.next(spec.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), ver)
 	spec.UniqueID = uniqueID
 
 	payload := gatewayRequestLoad{
@@ -60,7 +64,7 @@ func (s *Session) RequestOptionSeries(spec OptionSeriesRequestSignature) (*[]Opt
 			{
 				Header: gatewayHeader{
 					Service: "optionSeries",
-					Ver:     int(s.OptionSeriesRequestVers[spec.shortName()]),
+					Ver:     ver,
 					ID:      spec.UniqueID,
 				},
 				Params: gatewayParams{
@@ -70,56 +74,40 @@ func (s *Session) RequestOptionSeries(spec OptionSeriesRequestSignature) (*[]Opt
 		},
 	}
 
-	s.OptionSeriesRequestVers[spec.shortName()]++
-	s.sendJSON(payload)
-
-	internalChannel := make(chan storedCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second)
-	defer ctxCancel()
-
-	go func() {
-		for {
-			select {
+	key := stateKey{Service: "optionSeries", ID: spec.UniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+	defer s.state.remove(key)
 
-			case recvPayload := <-s.TransactionChannel:
-				if recvPayload.OptionSeries.RequestID == spec.UniqueID {
-					internalChannel <- recvPayload
-					return
-				}
-
-			case <-ctx.Done():
-				return
-
-			}
-		}
-	}()
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
 	select {
 
-	case recvPayload := <-internalChannel:
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
 		if len(recvPayload.OptionSeries.Series) == 0 {
 			return nil, ErrNotReceivedInTime
 		}
 		return &recvPayload.OptionSeries.Series, nil
 
 	case <-ctx.Done():
-		return nil, ErrNotReceivedInTime
+		return nil, s.requestFailed(ctx, key, "symbol", spec.Ticker)
 	}
 }
 
-func (s *Session) optionSeriesHandler(msg []byte, patch *gabs.Container) {
-	patchBody := patch.S("body", "patches", "0")
-
-	rID := patch.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
-
-	var state newOptionSeries
-	err := json.Unmarshal(patchBody.Bytes(), &state)
-	if err != nil {
+func (s *Session) optionSeriesHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+	if _, ok := s.state.apply(key, gab); !ok {
 		return
 	}
 
-	state.Value.RequestID = rID
-	s.CurrentState.OptionSeries = state.Value
-	s.TransactionChannel <- s.CurrentState
+	var state OptionSeriesCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
+
+	s.router.deliver(key, storedCache{OptionSeries: state})
 }
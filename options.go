@@ -0,0 +1,131 @@
+package flux
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a Session when it is created with New
+type Option func(*Session)
+
+// WithDebug logs every message received from the gateway (at the debug level)
+func WithDebug(debug bool) Option {
+	return func(s *Session) {
+		s.debug = debug
+	}
+}
+
+// WithHTTPClient sets the client used for the configuration endpoint and to
+// fetch access tokens, a nil client keeps the one in the tda.Session
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Session) {
+		if client == nil {
+			return
+		}
+		s.TdaSession.HttpClient = *client
+	}
+}
+
+// WithDialer opens connections through the provided Dialer instead of a
+// websocket, a MemoryDialer can be used here to run a Session without a
+// network
+func WithDialer(dialer Dialer) Option {
+	return func(s *Session) {
+		s.dialer = dialer
+	}
+}
+
+// WithConfigURL sets the configuration endpoint the gateway URL is looked up
+// from
+func WithConfigURL(url string) Option {
+	return func(s *Session) {
+		s.ConfigURL = url
+	}
+}
+
+// WithGatewayURL connects to the given gateway, skipping the configuration
+// lookup
+func WithGatewayURL(url string) Option {
+	return func(s *Session) {
+		s.GatewayURL = url
+	}
+}
+
+// WithEnvironment selects live or paper trading
+func WithEnvironment(env Environment) Option {
+	return func(s *Session) {
+		s.Environment = env
+	}
+}
+
+// WithProtocolVersion sets the protocol version asked for when connecting,
+// the default is "26.*.*"
+func WithProtocolVersion(ver string) Option {
+	return func(s *Session) {
+		s.ProtocolVersion = ver
+	}
+}
+
+// WithHeartbeat sets the heartbeat interval asked of the gateway and how many
+// may be missed before the connection is dropped, an interval of zero turns
+// the heartbeat watchdog off
+func WithHeartbeat(interval time.Duration, tolerance int) Option {
+	return func(s *Session) {
+		s.heartbeatInterval = interval
+		s.heartbeatTolerance = tolerance
+	}
+}
+
+// WithRequestTimeout sets how long requests made without a context wait for
+// a response
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Session) {
+		s.requestTimeout = timeout
+	}
+}
+
+// WithReconnectPolicy sets how the Session reconnects after losing its
+// connection
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(s *Session) {
+		s.reconnectPolicy = policy
+	}
+}
+
+// WithTokenRefresh sets how long access tokens last and how long before
+// expiring they are refreshed
+func WithTokenRefresh(lifetime, ahead time.Duration) Option {
+	return func(s *Session) {
+		s.tokenLifetime = lifetime
+		s.tokenRefreshAhead = ahead
+	}
+}
+
+// WithLogger sends the Session's log messages to logger instead of the
+// standard library's default logger, a nil logger discards them
+func WithLogger(logger Logger) Option {
+	return func(s *Session) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		s.logger = logger
+	}
+}
+
+// WithMetrics reports the Session's traffic and health to metrics (see
+// NewPrometheusMetrics), a nil metrics discards them
+func WithMetrics(metrics Metrics) Option {
+	return func(s *Session) {
+		if metrics == nil {
+			metrics = nopMetrics{}
+		}
+		s.metrics = metrics
+	}
+}
+
+// WithRecorder records every frame sent and received (see NewRecorder)
+func WithRecorder(recorder *Recorder) Option {
+	return func(s *Session) {
+		s.recorder = recorder
+	}
+}
@@ -0,0 +1,59 @@
+package flux
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestOptions(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	s, err := New(srv.TdaSession(),
+		WithConfigURL(srv.ConfigURL()),
+		WithHTTPClient(client),
+		WithRequestTimeout(3*time.Second),
+		WithHeartbeat(time.Second, 5),
+		WithTokenRefresh(time.Hour, time.Minute),
+		WithEnvironment(PaperMoney),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.TdaSession.HttpClient.Timeout != client.Timeout {
+		t.Error("the HTTP client was not set")
+	}
+	if s.requestTimeout != 3*time.Second || s.heartbeatInterval != time.Second || s.heartbeatTolerance != 5 {
+		t.Errorf("unexpected timeouts %v, %v, %d", s.requestTimeout, s.heartbeatInterval, s.heartbeatTolerance)
+	}
+	if s.tokenLifetime != time.Hour || s.tokenRefreshAhead != time.Minute {
+		t.Errorf("unexpected token refresh %v, %v", s.tokenLifetime, s.tokenRefreshAhead)
+	}
+	if s.Environment != PaperMoney {
+		t.Errorf("unexpected environment %v", s.Environment)
+	}
+}
+
+func TestNilOptions(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s, err := New(srv.TdaSession(),
+		WithConfigURL(srv.ConfigURL()),
+		WithHTTPClient(nil),
+		WithLogger(nil),
+		WithMetrics(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
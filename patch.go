@@ -0,0 +1,265 @@
+package flux
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+var (
+	errPatchPath = errors.New("patch path not found")
+	errPatchOp   = errors.New("patch operation not supported")
+	errPatchTest = errors.New("patch test failed")
+)
+
+// Patch is a single JSON patch (RFC 6902) operation, the gateway responds to
+// requests with patches to the request's document
+type Patch struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// responsePatches reads the patches of a payload entry
+func responsePatches(gab *gabs.Container) []Patch {
+	patches := []Patch{}
+	for _, patch := range gab.S("body", "patches").Children() {
+		op := Patch{}
+		op.Op, _ = patch.S("op").Data().(string)
+		op.Path, _ = patch.S("path").Data().(string)
+		op.From, _ = patch.S("from").Data().(string)
+		op.Value = patch.S("value").Data()
+		patches = append(patches, op)
+	}
+	return patches
+}
+
+// applyPatch applies an operation to a decoded JSON document (as produced by
+// encoding/json into an interface{}) and returns the patched document. Maps
+// and slices are patched in place, so the document has to be owned by the
+// caller, but nothing is changed if the operation fails
+func applyPatch(doc interface{}, op Patch) (interface{}, error) {
+	switch op.Op {
+
+	case "add", "replace", "remove":
+		tokens, err := pointerTokens(op.Path)
+		if err != nil {
+			return doc, err
+		}
+		return patchAt(doc, tokens, op.Op, deepCopy(op.Value))
+
+	case "move", "copy":
+		from, err := pointerTokens(op.From)
+		if err != nil {
+			return doc, err
+		}
+		value, err := valueAt(doc, from)
+		if err != nil {
+			return doc, err
+		}
+
+		tokens, err := pointerTokens(op.Path)
+		if err != nil {
+			return doc, err
+		}
+
+		if op.Op == "copy" {
+			return patchAt(doc, tokens, "add", deepCopy(value))
+		}
+
+		// the value is removed before it is added, so the move is made on a
+		// copy in case adding it fails
+		moved, err := patchAt(deepCopy(doc), from, "remove", nil)
+		if err != nil {
+			return doc, err
+		}
+		moved, err = patchAt(moved, tokens, "add", value)
+		if err != nil {
+			return doc, err
+		}
+		return moved, nil
+
+	case "test":
+		tokens, err := pointerTokens(op.Path)
+		if err != nil {
+			return doc, err
+		}
+		value, err := valueAt(doc, tokens)
+		if err != nil {
+			return doc, err
+		}
+		if !reflect.DeepEqual(value, op.Value) {
+			return doc, errPatchTest
+		}
+		return doc, nil
+
+	}
+
+	return doc, errPatchOp
+}
+
+// pointerTokens splits a JSON pointer (RFC 6901) into its unescaped tokens
+func pointerTokens(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, errPatchPath
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		if strings.Contains(token, "~") {
+			token = strings.Replace(token, "~1", "/", -1)
+			tokens[i] = strings.Replace(token, "~0", "~", -1)
+		}
+	}
+	return tokens, nil
+}
+
+// patchAt adds, replaces or removes the value at tokens
+func patchAt(node interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op {
+		case "add", "replace":
+			return value, nil
+		}
+		return node, errPatchPath
+	}
+
+	key, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+
+	case map[string]interface{}:
+		child, ok := n[key]
+		if !last {
+			if !ok {
+				return node, errPatchPath
+			}
+			updated, err := patchAt(child, tokens[1:], op, value)
+			if err != nil {
+				return node, err
+			}
+			n[key] = updated
+			return n, nil
+		}
+
+		switch op {
+		case "add":
+			n[key] = value
+		case "replace":
+			if !ok {
+				return node, errPatchPath
+			}
+			n[key] = value
+		case "remove":
+			if !ok {
+				return node, errPatchPath
+			}
+			delete(n, key)
+		}
+		return n, nil
+
+	case []interface{}:
+		if last && op == "add" {
+			if key == "-" {
+				return append(n, value), nil
+			}
+
+			i, err := arrayIndex(key, len(n)+1)
+			if err != nil {
+				return node, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+
+		i, err := arrayIndex(key, len(n))
+		if err != nil {
+			return node, err
+		}
+
+		if !last {
+			updated, err := patchAt(n[i], tokens[1:], op, value)
+			if err != nil {
+				return node, err
+			}
+			n[i] = updated
+			return n, nil
+		}
+
+		switch op {
+		case "replace":
+			n[i] = value
+		case "remove":
+			n = append(n[:i], n[i+1:]...)
+		}
+		return n, nil
+
+	}
+
+	return node, errPatchPath
+}
+
+// valueAt returns the value at tokens
+func valueAt(node interface{}, tokens []string) (interface{}, error) {
+	for _, key := range tokens {
+		switch n := node.(type) {
+
+		case map[string]interface{}:
+			child, ok := n[key]
+			if !ok {
+				return nil, errPatchPath
+			}
+			node = child
+
+		case []interface{}:
+			i, err := arrayIndex(key, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+
+		default:
+			return nil, errPatchPath
+		}
+	}
+	return node, nil
+}
+
+// arrayIndex parses an array index token, it has to be below length
+func arrayIndex(token string, length int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= length || (len(token) > 1 && token[0] == '0') {
+		return 0, errPatchPath
+	}
+	return i, nil
+}
+
+func deepCopy(node interface{}) interface{} {
+	switch n := node.(type) {
+
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(n))
+		for key, value := range n {
+			copied[key] = deepCopy(value)
+		}
+		return copied
+
+	case []interface{}:
+		copied := make([]interface{}, len(n))
+		for i, value := range n {
+			copied[i] = deepCopy(value)
+		}
+		return copied
+
+	}
+	return node
+}
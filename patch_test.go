@@ -0,0 +1,146 @@
+package flux
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		op   Patch
+		want string
+		err  error
+	}{
+		{"add member", `{"a":1}`, Patch{Op: "add", Path: "/b", Value: 2.0}, `{"a":1,"b":2}`, nil},
+		{"add replaces member", `{"a":1}`, Patch{Op: "add", Path: "/a", Value: 2.0}, `{"a":2}`, nil},
+		{"add nested", `{"a":{"b":1}}`, Patch{Op: "add", Path: "/a/c", Value: "x"}, `{"a":{"b":1,"c":"x"}}`, nil},
+		{"add missing parent", `{"a":1}`, Patch{Op: "add", Path: "/b/c", Value: 1.0}, `{"a":1}`, errPatchPath},
+		{"add root", `{"a":1}`, Patch{Op: "add", Path: "", Value: map[string]interface{}{"b": 2.0}}, `{"b":2}`, nil},
+		{"add inserts", `[1,2,3]`, Patch{Op: "add", Path: "/1", Value: 9.0}, `[1,9,2,3]`, nil},
+		{"add at length", `[1,2]`, Patch{Op: "add", Path: "/2", Value: 3.0}, `[1,2,3]`, nil},
+		{"add appends", `{"a":[1,2]}`, Patch{Op: "add", Path: "/a/-", Value: 3.0}, `{"a":[1,2,3]}`, nil},
+		{"add past length", `[1,2]`, Patch{Op: "add", Path: "/3", Value: 3.0}, `[1,2]`, errPatchPath},
+		{"add leading zero", `[1,2]`, Patch{Op: "add", Path: "/01", Value: 3.0}, `[1,2]`, errPatchPath},
+
+		{"replace member", `{"a":1,"b":2}`, Patch{Op: "replace", Path: "/b", Value: 3.0}, `{"a":1,"b":3}`, nil},
+		{"replace element", `{"a":[1,2]}`, Patch{Op: "replace", Path: "/a/0", Value: 5.0}, `{"a":[5,2]}`, nil},
+		{"replace missing member", `{"a":1}`, Patch{Op: "replace", Path: "/b", Value: 3.0}, `{"a":1}`, errPatchPath},
+		{"replace out of range", `[1,2]`, Patch{Op: "replace", Path: "/2", Value: 3.0}, `[1,2]`, errPatchPath},
+		{"replace negative index", `[1,2]`, Patch{Op: "replace", Path: "/-1", Value: 3.0}, `[1,2]`, errPatchPath},
+		{"replace append token", `[1,2]`, Patch{Op: "replace", Path: "/-", Value: 3.0}, `[1,2]`, errPatchPath},
+		{"replace leading zero", `[1,2]`, Patch{Op: "replace", Path: "/00", Value: 3.0}, `[1,2]`, errPatchPath},
+		{"replace in scalar", `{"a":1}`, Patch{Op: "replace", Path: "/a/b", Value: 3.0}, `{"a":1}`, errPatchPath},
+
+		{"remove member", `{"a":1,"b":2}`, Patch{Op: "remove", Path: "/a"}, `{"b":2}`, nil},
+		{"remove element", `[1,2,3]`, Patch{Op: "remove", Path: "/1"}, `[1,3]`, nil},
+		{"remove missing member", `{"a":1}`, Patch{Op: "remove", Path: "/b"}, `{"a":1}`, errPatchPath},
+		{"remove out of range", `[1,2]`, Patch{Op: "remove", Path: "/5"}, `[1,2]`, errPatchPath},
+		{"remove root", `{"a":1}`, Patch{Op: "remove", Path: ""}, `{"a":1}`, errPatchPath},
+
+		{"move member", `{"a":1,"b":{}}`, Patch{Op: "move", From: "/a", Path: "/b/c"}, `{"b":{"c":1}}`, nil},
+		{"move element", `[1,2,3]`, Patch{Op: "move", From: "/0", Path: "/2"}, `[2,3,1]`, nil},
+		{"move missing from", `{"a":1}`, Patch{Op: "move", From: "/b", Path: "/c"}, `{"a":1}`, errPatchPath},
+		{"move to missing parent", `{"a":1}`, Patch{Op: "move", From: "/a", Path: "/b/c"}, `{"a":1}`, errPatchPath},
+
+		{"copy member", `{"a":{"b":1}}`, Patch{Op: "copy", From: "/a", Path: "/c"}, `{"a":{"b":1},"c":{"b":1}}`, nil},
+		{"copy appends", `{"a":[1],"b":2}`, Patch{Op: "copy", From: "/b", Path: "/a/-"}, `{"a":[1,2],"b":2}`, nil},
+		{"copy missing from", `{"a":1}`, Patch{Op: "copy", From: "/b", Path: "/c"}, `{"a":1}`, errPatchPath},
+
+		{"test equal", `{"a":[1,"x"]}`, Patch{Op: "test", Path: "/a", Value: []interface{}{1.0, "x"}}, `{"a":[1,"x"]}`, nil},
+		{"test not equal", `{"a":1}`, Patch{Op: "test", Path: "/a", Value: 2.0}, `{"a":1}`, errPatchTest},
+		{"test missing", `{"a":1}`, Patch{Op: "test", Path: "/b", Value: 1.0}, `{"a":1}`, errPatchPath},
+
+		{"escaped slash", `{"a/b":1}`, Patch{Op: "replace", Path: "/a~1b", Value: 2.0}, `{"a/b":2}`, nil},
+		{"escaped tilde", `{"a~b":1}`, Patch{Op: "replace", Path: "/a~0b", Value: 2.0}, `{"a~b":2}`, nil},
+		{"escapes in order", `{"~1":1,"/":2}`, Patch{Op: "remove", Path: "/~01"}, `{"/":2}`, nil},
+		{"escaped from", `{"a/b":1}`, Patch{Op: "move", From: "/a~1b", Path: "/c~0d"}, `{"c~d":1}`, nil},
+		{"empty member name", `{"":1}`, Patch{Op: "replace", Path: "/", Value: 2.0}, `{"":2}`, nil},
+
+		{"path without slash", `{"a":1}`, Patch{Op: "replace", Path: "a", Value: 2.0}, `{"a":1}`, errPatchPath},
+		{"unknown op", `{"a":1}`, Patch{Op: "merge", Path: "/a", Value: 2.0}, `{"a":1}`, errPatchOp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			patched, err := applyPatch(doc, tt.op)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			// a failed operation returns the document it was given, which
+			// must not have been changed in place either
+			if err != nil {
+				assertJSON(t, doc, tt.doc)
+			}
+			assertJSON(t, patched, tt.want)
+		})
+	}
+}
+
+func TestStoreSkipsFailedPatches(t *testing.T) {
+	st := newStateStore(nopMetrics{})
+	key := stateKey{Service: "quotes", ID: "QUOTES-0"}
+
+	frame := func(patches ...Patch) *gabs.Container {
+		gab := gabs.New()
+		gab.Set("quotes", "header", "service")
+		gab.Set("QUOTES-0", "header", "id")
+		gab.Set(patches, "body", "patches")
+		parsed, err := gabs.ParseJSON(gab.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	if _, ok := st.apply(key, frame(Patch{Op: "replace", Path: "/a", Value: 1.0})); ok {
+		t.Fatal("a patch was applied to a document that does not exist")
+	}
+
+	doc, ok := st.apply(key, frame(Patch{Op: "replace", Path: "", Value: map[string]interface{}{"a": []interface{}{1.0, 2.0}}}))
+	if !ok {
+		t.Fatal("the snapshot was not applied")
+	}
+	assertJSON(t, json.RawMessage(doc), `{"a":[1,2]}`)
+
+	doc, ok = st.apply(key, frame(
+		Patch{Op: "move", From: "/a/0", Path: "/b/c"},
+		Patch{Op: "replace", Path: "/a/1", Value: 3.0},
+		Patch{Op: "add", Path: "/a/-", Value: 4.0},
+	))
+	if !ok {
+		t.Fatal("the patches were not applied")
+	}
+	assertJSON(t, json.RawMessage(doc), `{"a":[1,3,4]}`)
+
+	var stored interface{}
+	st.get(key, &stored)
+	assertJSON(t, stored, `{"a":[1,3,4]}`)
+}
+
+// assertJSON fails the test if v does not marshal to the same JSON as want
+func assertJSON(t *testing.T, v interface{}, want string) {
+	t.Helper()
+
+	got, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotValue, wantValue interface{}
+	json.Unmarshal(got, &gotValue)
+	json.Unmarshal([]byte(want), &wantValue)
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,222 @@
+package flux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the request latency
+// histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusMetrics keeps the metrics of a Session in memory and serves them
+// over HTTP in the Prometheus text exposition format
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	frames     map[string]uint64
+	applied    map[string]uint64
+	failed     map[string]uint64
+	requests   map[[2]string]uint64
+	timeouts   map[string]uint64
+	latency    map[string]*histogram
+	reconnects map[string]uint64
+	queues     map[string]int64
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics, pass it to New with
+// WithMetrics and serve it on the metrics path:
+//
+//	http.Handle("/metrics", metrics)
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		frames:     make(map[string]uint64),
+		applied:    make(map[string]uint64),
+		failed:     make(map[string]uint64),
+		requests:   make(map[[2]string]uint64),
+		timeouts:   make(map[string]uint64),
+		latency:    make(map[string]*histogram),
+		reconnects: make(map[string]uint64),
+		queues:     make(map[string]int64),
+	}
+}
+
+// FrameReceived counts a response received for a service
+func (m *PrometheusMetrics) FrameReceived(service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames[service]++
+}
+
+// PatchesApplied counts the patches applied and failed for a service
+func (m *PrometheusMetrics) PatchesApplied(service string, applied, failed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied[service] += uint64(applied)
+	m.failed[service] += uint64(failed)
+}
+
+// RequestCompleted counts a request by its result and observes its latency
+func (m *PrometheusMetrics) RequestCompleted(method string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrNotReceivedInTime):
+		result = "timeout"
+		m.timeouts[method]++
+	case err != nil:
+		result = "error"
+	}
+	m.requests[[2]string{method, result}]++
+
+	h, ok := m.latency[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[method] = h
+	}
+
+	seconds := latency.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ReconnectAttempted counts a reconnect attempt by its result
+func (m *PrometheusMetrics) ReconnectAttempted(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.reconnects["error"]++
+	} else {
+		m.reconnects["ok"]++
+	}
+}
+
+// QueueDepthChanged tracks the number of updates waiting to be read
+func (m *PrometheusMetrics) QueueDepthChanged(queue string, delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[queue] += int64(delta)
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes every metric to w in the Prometheus text exposition format
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	header(&b, "flux_frames_received_total", "counter", "Responses received from the gateway by service.")
+	for _, service := range sortedKeys(m.frames) {
+		fmt.Fprintf(&b, "flux_frames_received_total{service=%s} %d\n", label(service), m.frames[service])
+	}
+
+	header(&b, "flux_patches_applied_total", "counter", "Patches applied to documents by service.")
+	for _, service := range sortedKeys(m.applied) {
+		fmt.Fprintf(&b, "flux_patches_applied_total{service=%s} %d\n", label(service), m.applied[service])
+	}
+
+	header(&b, "flux_patches_failed_total", "counter", "Patches that could not be applied by service.")
+	for _, service := range sortedKeys(m.failed) {
+		fmt.Fprintf(&b, "flux_patches_failed_total{service=%s} %d\n", label(service), m.failed[service])
+	}
+
+	header(&b, "flux_requests_total", "counter", "Requests made by method and result.")
+	requests := make([][2]string, 0, len(m.requests))
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i][0] != requests[j][0] {
+			return requests[i][0] < requests[j][0]
+		}
+		return requests[i][1] < requests[j][1]
+	})
+	for _, key := range requests {
+		fmt.Fprintf(&b, "flux_requests_total{method=%s,result=%s} %d\n", label(key[0]), label(key[1]), m.requests[key])
+	}
+
+	header(&b, "flux_request_timeouts_total", "counter", "Requests that timed out by method.")
+	for _, method := range sortedKeys(m.timeouts) {
+		fmt.Fprintf(&b, "flux_request_timeouts_total{method=%s} %d\n", label(method), m.timeouts[method])
+	}
+
+	header(&b, "flux_request_duration_seconds", "histogram", "Time taken by requests by method.")
+	methods := make([]string, 0, len(m.latency))
+	for method := range m.latency {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		h := m.latency[method]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&b, "flux_request_duration_seconds_bucket{method=%s,le=\"%g\"} %d\n", label(method), bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "flux_request_duration_seconds_bucket{method=%s,le=\"+Inf\"} %d\n", label(method), h.count)
+		fmt.Fprintf(&b, "flux_request_duration_seconds_sum{method=%s} %g\n", label(method), h.sum)
+		fmt.Fprintf(&b, "flux_request_duration_seconds_count{method=%s} %d\n", label(method), h.count)
+	}
+
+	header(&b, "flux_reconnect_attempts_total", "counter", "Reconnect attempts by result.")
+	for _, result := range sortedKeys(m.reconnects) {
+		fmt.Fprintf(&b, "flux_reconnect_attempts_total{result=%s} %d\n", label(result), m.reconnects[result])
+	}
+
+	header(&b, "flux_queue_depth", "gauge", "Updates waiting to be read by queue.")
+	queues := make([]string, 0, len(m.queues))
+	for queue := range m.queues {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	for _, queue := range queues {
+		fmt.Fprintf(&b, "flux_queue_depth{queue=%s} %d\n", label(queue), m.queues[queue])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelEscaper escapes a label value as the exposition format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label quotes a label value
+func label(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package flux
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestPrometheusExposition(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.RequestCompleted("RequestChart", 3*time.Millisecond, nil)
+	m.RequestCompleted("RequestChart", 200*time.Millisecond, nil)
+	m.RequestCompleted("RequestChart", 20*time.Second, ErrNotReceivedInTime)
+	m.RequestCompleted("RequestQuote", 40*time.Millisecond, &ServiceError{Code: 404})
+	m.FrameReceived(`we"ird\service` + "\n")
+	m.QueueDepthChanged("quotes", 3)
+	m.QueueDepthChanged("quotes", -1)
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, line := range []string{
+		// buckets are cumulative and +Inf holds every observation
+		`flux_request_duration_seconds_bucket{method="RequestChart",le="0.005"} 1`,
+		`flux_request_duration_seconds_bucket{method="RequestChart",le="0.1"} 1`,
+		`flux_request_duration_seconds_bucket{method="RequestChart",le="0.25"} 2`,
+		`flux_request_duration_seconds_bucket{method="RequestChart",le="10"} 2`,
+		`flux_request_duration_seconds_bucket{method="RequestChart",le="+Inf"} 3`,
+		`flux_request_duration_seconds_sum{method="RequestChart"} 20.203`,
+		`flux_request_duration_seconds_count{method="RequestChart"} 3`,
+		`flux_request_duration_seconds_bucket{method="RequestQuote",le="0.025"} 0`,
+		`flux_request_duration_seconds_bucket{method="RequestQuote",le="0.05"} 1`,
+
+		`flux_requests_total{method="RequestChart",result="ok"} 2`,
+		`flux_requests_total{method="RequestChart",result="timeout"} 1`,
+		`flux_requests_total{method="RequestQuote",result="error"} 1`,
+		`flux_request_timeouts_total{method="RequestChart"} 1`,
+
+		`flux_frames_received_total{service="we\"ird\\service\n"} 1`,
+		`flux_queue_depth{queue="quotes"} 2`,
+		"# TYPE flux_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if strings.Contains(out, `flux_request_timeouts_total{method="RequestQuote"}`) {
+		t.Error("a service error was counted as a timeout")
+	}
+	if t.Failed() {
+		t.Log(out)
+	}
+}
+
+func TestMultipleChartsMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"chart_v27": func(req fluxtest.Request) []fluxtest.Patch {
+			var symbol string
+			req.Param("symbol", &symbol)
+			if symbol == "BADSYM" {
+				return []fluxtest.Patch{fluxtest.Error(404, "symbol not found")}
+			}
+			return fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1})(req)
+		},
+	}, WithMetrics(metrics))
+
+	charts, errored := s.RequestMultipleCharts([]ChartRequestSignature{
+		{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"},
+		{Ticker: "BADSYM", Range: "DAY1", Width: "HOUR1"},
+	})
+	if len(charts) != 1 || len(errored) != 1 {
+		t.Fatalf("got %d charts, %d errored", len(charts), len(errored))
+	}
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	out := b.String()
+	if !strings.Contains(out, `flux_requests_total{method="RequestMultipleCharts",result="error"} 1`) {
+		t.Fatalf("the service error was not reported as an error:\n%s", out)
+	}
+	if strings.Contains(out, `flux_request_timeouts_total{method="RequestMultipleCharts"}`) {
+		t.Fatalf("the service error was counted as a timeout:\n%s", out)
+	}
+}
+
+func TestMultipleChartsTimeoutMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	s := newMemorySession(t, nil, WithMetrics(metrics), WithRequestTimeout(50*time.Millisecond))
+
+	if _, errored := s.RequestMultipleCharts([]ChartRequestSignature{{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"}}); len(errored) != 1 {
+		t.Fatal("the chart did not time out")
+	}
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	if !strings.Contains(b.String(), `flux_request_timeouts_total{method="RequestMultipleCharts"} 1`) {
+		t.Fatalf("the timeout was not counted:\n%s", b.String())
+	}
+}
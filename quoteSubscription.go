@@ -0,0 +1,239 @@
+package flux
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// QuoteUpdate is sent on a quote subscription for every field of a symbol
+// that the provisioner sends a value for
+type QuoteUpdate struct {
+	Symbol string
+	Field  QuoteField
+	Value  interface{}
+	Time   time.Time
+
+	// Err is set (and nothing else) when the provisioner responded with a
+	// *ServiceError (such as for a symbol that does not exist) or the request
+	// could not be sent, a request that could not be sent is sent again once
+	// the session reconnects
+	Err error
+}
+
+// QuoteSubscription is a live quote subscription created by SubscribeQuotes,
+// symbols can be added and removed while it is running
+type QuoteSubscription struct {
+	// Updates receives every update until Cancel is called, it is then closed
+	Updates <-chan QuoteUpdate
+
+	s       *Session
+	sub     *subscription
+	mu      sync.Mutex
+	ver     int
+	symbols []string
+	fields  []QuoteField
+
+	// cancelled is set by Cancel, Add and Remove do nothing after it
+	cancelled bool
+}
+
+// quoteDocument is the untyped form of a quotes document, used to read any
+// field regardless of whether QuoteStoredCache knows about it
+type quoteDocument struct {
+	Items []struct {
+		Symbol string                 `json:"symbol"`
+		Values map[string]interface{} `json:"values"`
+	} `json:"items"`
+}
+
+// quoteUpdates builds the updates for a patched quotes document, a snapshot
+// sends every value while /items/N/values/FIELD patches send a single value
+func quoteUpdates(gab *gabs.Container, doc []byte) []QuoteUpdate {
+	var quote quoteDocument
+	if err := json.Unmarshal(doc, &quote); err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	updates := []QuoteUpdate{}
+	itemUpdates := func(index int) {
+		if index < 0 || index >= len(quote.Items) {
+			return
+		}
+
+		item := quote.Items[index]
+		for field, value := range item.Values {
+			updates = append(updates, QuoteUpdate{
+				Symbol: item.Symbol,
+				Field:  QuoteField(field),
+				Value:  value,
+				Time:   now,
+			})
+		}
+	}
+
+	for _, patch := range gab.S("body", "patches").Children() {
+		path, _ := patch.S("path").Data().(string)
+		parts := strings.Split(path, "/")
+
+		switch {
+
+		// the whole document was replaced
+		case path == "" || path == "/items":
+			for index := range quote.Items {
+				itemUpdates(index)
+			}
+
+		// a single item was replaced
+		case len(parts) == 3 && parts[1] == "items":
+			index, _ := strconv.Atoi(parts[2])
+			itemUpdates(index)
+
+		// a single value of an item was replaced
+		case len(parts) == 5 && parts[1] == "items" && parts[3] == "values":
+			index, err := strconv.Atoi(parts[2])
+			if err != nil || index >= len(quote.Items) {
+				continue
+			}
+
+			updates = append(updates, QuoteUpdate{
+				Symbol: quote.Items[index].Symbol,
+				Field:  QuoteField(parts[4]),
+				Value:  patch.S("value").Data(),
+				Time:   now,
+			})
+		}
+	}
+
+	return updates
+}
+
+// quoteRequest builds the quotes request for a set of symbols
+func quoteRequest(uniqueID string, ver int, symbols []string, refreshRate int, fields []QuoteField) gatewayRequest {
+	return gatewayRequest{
+		Header: gatewayHeader{
+			Service: "quotes",
+			ID:      uniqueID,
+			Ver:     ver,
+		},
+		Params: gatewayParams{
+			Account:     "COMBINED ACCOUNT",
+			Symbols:     symbols,
+			RefreshRate: refreshRate,
+			QuoteFields: fields,
+		},
+	}
+}
+
+// SubscribeQuotes subscribes to the fields of a set of symbols, the current
+// values are sent on Updates once the provisioner responds and every change
+// after that is sent as it arrives
+func (s *Session) SubscribeQuotes(symbols []string, fields []QuoteField) *QuoteSubscription {
+	uniqueID := fmt.Sprintf("QUOTESUB-%d", s.versions.next("QUOTESUB"))
+
+	updates := make(chan QuoteUpdate)
+	q := &QuoteSubscription{
+		Updates: updates,
+		s:       s,
+		sub:     s.subscribe(stateKey{Service: "quotes", ID: uniqueID}),
+		fields:  fields,
+	}
+
+	go func() {
+		defer close(updates)
+		q.sub.events.drain(func(v interface{}, done <-chan struct{}) {
+			update, ok := v.(QuoteUpdate)
+			if err, failed := v.(error); failed {
+				update, ok = QuoteUpdate{Err: err}, true
+			}
+			if !ok {
+				return
+			}
+
+			select {
+			case updates <- update:
+			case <-done:
+			}
+		})
+	}()
+
+	q.Add(symbols...)
+	return q
+}
+
+// Symbols returns the symbols currently subscribed to
+func (q *QuoteSubscription) Symbols() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string{}, q.symbols...)
+}
+
+// Add adds symbols to the subscription, symbols already subscribed to are
+// ignored. It does nothing once the subscription is cancelled
+func (q *QuoteSubscription) Add(symbols ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancelled {
+		return
+	}
+
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		if q.index(symbol) == -1 {
+			q.symbols = append(q.symbols, symbol)
+		}
+	}
+	q.send()
+}
+
+// Remove removes symbols from the subscription, it does nothing once the
+// subscription is cancelled
+func (q *QuoteSubscription) Remove(symbols ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancelled {
+		return
+	}
+
+	for _, symbol := range symbols {
+		if i := q.index(strings.ToUpper(symbol)); i != -1 {
+			q.symbols = append(q.symbols[:i], q.symbols[i+1:]...)
+		}
+	}
+	q.send()
+}
+
+// Cancel ends the subscription and closes Updates
+func (q *QuoteSubscription) Cancel() {
+	q.mu.Lock()
+	q.cancelled = true
+	q.mu.Unlock()
+
+	q.s.unsubscribe(q.sub)
+}
+
+func (q *QuoteSubscription) index(symbol string) int {
+	for i, subscribed := range q.symbols {
+		if subscribed == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+// send (re)sends the request for the current symbols, the provisioner
+// responds to a new version of a request with a fresh snapshot
+func (q *QuoteSubscription) send() {
+	request := quoteRequest(q.sub.key.ID, q.ver, append([]string{}, q.symbols...), 0, q.fields)
+
+	q.ver++
+	if err := q.s.sendSubscription(q.sub, request); err != nil {
+		q.sub.events.push(err)
+	}
+}
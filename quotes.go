@@ -2,14 +2,11 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Jeffail/gabs/v2"
-	jsonpatch "github.com/evanphx/json-patch"
 )
 
 // QuoteField is a custom type for quote fields (backed by string)
@@ -176,140 +173,129 @@ type quoteValues struct {
 	VWAP                       float64 `json:"VWAP,omitempty"`
 }
 
-type newQuoteObject struct {
-	Op    string           `json:"op"`
-	Path  string           `json:"path"`
-	Value QuoteStoredCache `json:"value"`
-}
-
-type updatedQuoteObject struct {
-	Op    string  `json:"op"`
-	Path  string  `json:"path"`
-	Value float64 `json:"value"`
-}
-
 func (s *Session) quoteHandler(msg []byte, gab *gabs.Container) {
-	// get the request id from the header
-	rID := gab.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
-	rService := gab.Search("header", "service").String()
-	rVerStr := gab.Search("header", "ver").String()
-	rVer, _ := strconv.Atoi(rVerStr)
-	rService = rService[1 : len(rService)-1]
-
-	newState := s.CurrentState
-
-	// get the patches
-	patches := gab.S("body", "patches").Children()
-	for _, patch := range patches {
-
-		// fmt.Printf("%d - %s (%s)\n", rVer, patch.S("path").String(), patch.S("value").String())
+	key := headerKey(gab)
+	doc, ok := s.state.apply(key, gab)
+	if !ok {
+		return
+	}
 
-		if patch.S("path").String() == "/error" {
-			continue
+	if sub, ok := s.subscriptions.get(key); ok {
+		for _, update := range quoteUpdates(gab, doc) {
+			sub.events.push(update)
 		}
+	}
 
-		path := patch.S("path").String()
-		if path == "\"\"" {
-			newState.Quote = QuoteStoredCache{}
-		}
-		patch.Set(fmt.Sprintf("/quote%s", path[1:len(path)-1]), "path")
+	var state QuoteStoredCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
+	state.Service = key.Service
 
-		bytesJSON, _ := patch.MarshalJSON()
-		patchStr := "[" + string(bytesJSON) + "]"
-		jspatch, err := jsonpatch.DecodePatch([]byte(patchStr))
+	s.router.deliver(key, storedCache{Quote: state})
+}
 
-		if err != nil {
-			continue
-		}
+// cachedQuote returns the quote held for the latest request made for a spec,
+// it is only returned once every item has been populated
+func (s *Session) cachedQuote(specs QuoteRequestSignature) (*QuoteStoredCache, bool) {
+	ver, ok := s.versions.last(specs.shortName())
+	if !ok {
+		return nil, false
+	}
 
-		byteState, _ := json.Marshal(newState)
+	key := stateKey{
+		Service: "quotes",
+		ID:      fmt.Sprintf("%s-%d", specs.shortName(), ver),
+	}
 
-		byteState, err = jspatch.Apply(byteState)
-		if err != nil {
-			continue
-		}
+	var quote QuoteStoredCache
+	if !s.state.get(key, &quote) || !quote.populated() {
+		return nil, false
+	}
+	quote.RequestID = key.ID
+	quote.Service = key.Service
 
-		json.Unmarshal(byteState, &newState)
-		newState.Quote.RequestID = rID
-		newState.Quote.Service = rService
-		newState.Quote.Ver = int(rVer)
+	return &quote, true
+}
 
+// populated is true once the quote has items and none of them are empty
+func (q *QuoteStoredCache) populated() bool {
+	if len(q.Items) == 0 {
+		return false
 	}
 
-	s.CurrentState = newState
-	s.NotificationChannel <- true
-	s.TransactionChannel <- newState
+	for _, val := range q.Items {
+		if (val == QuoteItem{}) {
+			return false
+		}
+	}
+	return true
 }
 
 // RequestQuote returns the quote for the relevant spec with the fields requested
 func (s *Session) RequestQuote(specs QuoteRequestSignature) (*QuoteStoredCache, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestQuoteContext(ctx, specs)
+}
+
+// RequestQuoteContext is the same as RequestQuote but honours the deadline and
+// cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestQuoteContext(ctx context.Context, specs QuoteRequestSignature) (quote *QuoteStoredCache, err error) {
+	defer s.observeRequest("RequestQuote", time.Now(), &err)
 
 	// force capitalization of tickers, since the socket is case sensitive
 	specs.Ticker = strings.ToUpper(specs.Ticker)
-	uniqueID := fmt.Sprintf("%s-%d", specs.shortName(), s.QuoteRequestVers[specs.shortName()])
 
-	if len(s.CurrentState.Quote.Items) != 0 &&
-		s.CurrentState.Quote.Ver == s.specHash(fmt.Sprintf("%s-%d", specs.shortName(), s.QuoteRequestVers[specs.shortName()]-1)) {
-		return &s.CurrentState.Quote, nil
+	if quote, ok := s.cachedQuote(specs); ok {
+		return quote, nil
 	}
 
-	hash := s.specHash(uniqueID)
+	ver := s.versions.next(specs.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", specs.shortName(), ver)
+
 	payload := gatewayRequestLoad{
 		Payload: []gatewayRequest{
-			{
-				Header: gatewayHeader{
-					Service: "quotes",
-					ID:      "fluxQuotes",
-					Ver:     hash,
-				},
-				Params: gatewayParams{
-					Account: "COMBINED ACCOUNT",
-					// supports multi-quoting (see comments on #16)
-					Symbols:     strings.Split(specs.Ticker, ","),
-					RefreshRate: specs.RefreshRate,
-					QuoteFields: specs.Fields,
-				},
-			},
+			// supports multi-quoting (see comments on #16)
+			quoteRequest(uniqueID, ver,
+				strings.Split(specs.Ticker, ","), specs.RefreshRate, specs.Fields),
 		},
 	}
 
-	s.QuoteMu.Lock()
-	defer s.QuoteMu.Unlock()
-	s.QuoteRequestVers[specs.shortName()]++
-	s.sendJSON(payload)
+	key := stateKey{Service: "quotes", ID: uniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
 
-	ctx, ctxCancel := context.WithTimeout(context.Background(), 1000*time.Millisecond)
-	defer ctxCancel()
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
+	// wait for every item of the quote to be populated
 	for {
 		select {
-		case <-ctx.Done():
-			return nil, ErrNotReceivedInTime
-
-		case <-s.NotificationChannel:
-			if s.CurrentState.Quote.Ver == hash {
-				cl := time.Now()
-				for {
-					if len(s.CurrentState.Quote.Items) != 0 {
-						flag := false
-						for _, val := range s.CurrentState.Quote.Items {
-							if (val == QuoteItem{}) {
-								flag = true
-							}
-						}
-						if !flag {
-							time.Sleep(500 * time.Millisecond)
-							return &s.CurrentState.Quote, nil
-						}
-					} else if time.Now().Sub(cl).Milliseconds() > 1000 {
-						if len(s.CurrentState.Quote.Items) == 0 {
-							return nil, ErrNotReceivedInTime
-						}
-						return &s.CurrentState.Quote, nil
-					}
-				}
+
+		case recvPayload := <-responses:
+			if recvPayload.err != nil {
+				return nil, recvPayload.err
+			}
+			if !recvPayload.Quote.populated() {
+				continue
 			}
+
+			// the values are patched in shortly after the items are populated,
+			// they are read from this request's document since a later
+			// request for the spec may not have been answered
+			settle(ctx, 500*time.Millisecond)
+			var quote QuoteStoredCache
+			if !s.state.get(key, &quote) || !quote.populated() {
+				return nil, ErrNotReceivedInTime
+			}
+			quote.RequestID = key.ID
+			quote.Service = key.Service
+			return &quote, nil
+
+		case <-ctx.Done():
+			return nil, s.requestFailed(ctx, key, "symbol", specs.Ticker)
 		}
 	}
 }
@@ -0,0 +1,145 @@
+package flux
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ReconnectPolicy controls how a Session reconnects after losing its
+// connection, the delay before an attempt starts at InitialDelay and grows by
+// Multiplier with every failed attempt up to MaxDelay
+type ReconnectPolicy struct {
+	// InitialDelay is the delay before the first attempt, the default's is
+	// used if it is not positive
+	InitialDelay time.Duration
+
+	// MaxDelay caps the delay, zero leaves it uncapped
+	MaxDelay time.Duration
+
+	// Multiplier grows the delay after every failed attempt, anything below 1
+	// is treated as 1 so that the delay never shrinks
+	Multiplier float64
+
+	// Jitter is the fraction of each delay that is randomized (between 0 and
+	// 1) so that many sessions do not reconnect in lockstep
+	Jitter float64
+
+	// MaxAttempts is the number of attempts made before giving up, zero
+	// retries forever
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy is the policy a Session is created with
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  0,
+	}
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// minReconnectDelay is the least a jittered delay is allowed to come out as
+const minReconnectDelay = time.Millisecond
+
+// Delay returns the delay before the given attempt (starting at 1)
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = DefaultReconnectPolicy().InitialDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 1
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		jitterMu.Lock()
+		r := jitterRand.Float64()
+		jitterMu.Unlock()
+
+		// spread the delay evenly over [delay - jitter, delay + jitter]
+		delay += delay * p.Jitter * (2*r - 1)
+	}
+
+	if delay < float64(minReconnectDelay) {
+		delay = float64(minReconnectDelay)
+	}
+	return time.Duration(delay)
+}
+
+// Err returns the error the Session stopped reconnecting with, it is nil
+// while the Session is connected or still reconnecting
+func (s *Session) Err() error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.err
+}
+
+// reconnect reopens the connection following the session's ReconnectPolicy,
+// it gives up once the policy runs out of attempts or the session is closed
+func (s *Session) reconnect(cause error) {
+	policy := s.reconnectPolicy
+
+	for attempt := 1; ; attempt++ {
+		if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+			err := fmt.Errorf("%w after %d attempts: %v", ErrReconnectFailed, policy.MaxAttempts, cause)
+			s.logger.Error("giving up reconnecting", "attempts", policy.MaxAttempts, "err", cause)
+
+			s.connMu.Lock()
+			s.err = err
+			s.connMu.Unlock()
+
+			s.emit(ReconnectFailed{Attempts: policy.MaxAttempts, Err: err})
+			return
+		}
+
+		delay := policy.Delay(attempt)
+		s.emit(Reconnecting{Attempt: attempt, Delay: delay, Err: cause})
+		s.logger.Warn("reconnecting", "attempt", attempt, "delay", delay, "err", cause)
+		time.Sleep(delay)
+
+		// the session was closed while waiting, so stop here
+		if s.isShutdown() {
+			return
+		}
+
+		err := s.Reset()
+		if err == nil {
+			err = s.open()
+		}
+		s.metrics.ReconnectAttempted(err)
+		if err == nil || s.isShutdown() {
+			return
+		}
+		cause = err
+	}
+}
+
+// isShutdown is true once Close has been called, until the next Open
+func (s *Session) isShutdown() bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.shutdown
+}
@@ -0,0 +1,153 @@
+package flux
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ReconnectPolicy
+		want   []time.Duration
+	}{
+		{
+			"doubling",
+			ReconnectPolicy{InitialDelay: time.Second, Multiplier: 2},
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			"capped",
+			ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 3 * time.Second, Multiplier: 2},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			"no multiplier",
+			ReconnectPolicy{InitialDelay: time.Second, MaxDelay: time.Minute},
+			[]time.Duration{time.Second, time.Second, time.Second, time.Second},
+		},
+		{
+			"shrinking multiplier",
+			ReconnectPolicy{InitialDelay: time.Second, Multiplier: 0.5},
+			[]time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			"no initial delay",
+			ReconnectPolicy{Multiplier: 2},
+			[]time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second},
+		},
+		{
+			"zero policy",
+			ReconnectPolicy{},
+			[]time.Duration{500 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			"max delay below initial delay",
+			ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 100 * time.Millisecond, Multiplier: 2},
+			[]time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				if got := tt.policy.Delay(i + 1); got != want {
+					t.Fatalf("attempt %d: expected %v, got %v", i+1, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReconnectPolicyJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ReconnectPolicy
+		min, max time.Duration
+	}{
+		{"fifth", ReconnectPolicy{InitialDelay: time.Second, Jitter: 0.2}, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"whole", ReconnectPolicy{InitialDelay: time.Second, Jitter: 1}, minReconnectDelay, 2 * time.Second},
+		{"more than whole", ReconnectPolicy{InitialDelay: time.Second, Jitter: 5}, minReconnectDelay, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := tt.policy.Delay(1); got < tt.min || got > tt.max {
+					t.Fatalf("expected a delay in [%v, %v], got %v", tt.min, tt.max, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReconnectFailed(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithReconnectPolicy(ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		Multiplier:   1,
+		MaxAttempts:  2,
+	}))
+	defer s.Close()
+	events := s.Events()
+
+	// every attempt fails to log in, so the policy runs out of attempts
+	srv.RejectLogin("invalid access token")
+	srv.Disconnect()
+
+	attempts := 0
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case Reconnecting:
+				attempts++
+				if e.Attempt != attempts {
+					t.Fatalf("expected attempt %d, got %d", attempts, e.Attempt)
+				}
+			case ReconnectFailed:
+				if attempts != 2 || e.Attempts != 2 || !errors.Is(e.Err, ErrReconnectFailed) {
+					t.Fatalf("unexpected %+v after %d attempts", e, attempts)
+				}
+				if err := s.Err(); !errors.Is(err, ErrReconnectFailed) {
+					t.Fatalf("expected Err to wrap ErrReconnectFailed, got %v", err)
+				}
+				if s.Established() {
+					t.Fatal("the session is still established")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the session did not give up, %d attempts were made", attempts)
+		}
+	}
+}
+
+func TestReconnectErrNil(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+	events := s.Events()
+
+	srv.Disconnect()
+	timeout := time.After(2 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case e := <-events:
+			_, reconnected = e.(LoggedIn)
+		case <-timeout:
+			t.Fatal("the session did not reconnect")
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("expected no error once reconnected, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package flux
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DirectionIn marks a recorded frame received from the gateway
+	DirectionIn = "in"
+
+	// DirectionOut marks a recorded frame sent to the gateway
+	DirectionOut = "out"
+)
+
+// RecordedFrame is a single line of a recording
+type RecordedFrame struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Recorder writes every frame a Session sends and receives to a writer as
+// JSON lines, pass it to New with WithRecorder
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) record(direction string, data []byte) {
+	if r == nil || r.enc == nil {
+		return
+	}
+
+	frame := RecordedFrame{
+		Time:      time.Now(),
+		Direction: direction,
+		Data:      json.RawMessage(data),
+	}
+
+	// frames that are not valid JSON are kept as a JSON string instead
+	if !json.Valid(data) {
+		frame.Data, _ = json.Marshal(string(data))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(frame)
+}
+
+func (r *Recorder) recordJSON(direction string, v interface{}) {
+	if r == nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	r.record(direction, data)
+}
+
+// LoadRecording reads a recording written by a Recorder
+func LoadRecording(rd io.Reader) ([]RecordedFrame, error) {
+	frames := []RecordedFrame{}
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var frame RecordedFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, scanner.Err()
+}
+
+// ReplayDialer is a Dialer that plays a recording back to the Session, every
+// inbound frame is delivered in order, spaced out by the original delay
+// divided by Speed (a Speed of zero delivers frames as fast as possible).
+// When the next recorded frame is outbound the replay waits for the Session
+// to write a frame, so responses are not delivered before they are requested
+type ReplayDialer struct {
+	Frames []RecordedFrame
+	Speed  float64
+
+	// done is made on first use so that a ReplayDialer literal works too
+	done      chan struct{}
+	doneOnce  sync.Once
+	closeOnce sync.Once
+}
+
+// NewReplayDialer returns a ReplayDialer for the frames at the given speed
+func NewReplayDialer(frames []RecordedFrame, speed float64) *ReplayDialer {
+	return &ReplayDialer{
+		Frames: frames,
+		Speed:  speed,
+	}
+}
+
+// Done is closed once every frame of the recording has been replayed
+func (r *ReplayDialer) Done() <-chan struct{} {
+	return r.doneChan()
+}
+
+func (r *ReplayDialer) doneChan() chan struct{} {
+	r.doneOnce.Do(func() {
+		r.done = make(chan struct{})
+	})
+	return r.done
+}
+
+// Dial starts replaying the recording, the url and header are ignored
+func (r *ReplayDialer) Dial(url string, header http.Header) (Conn, error) {
+	client, server := memoryPipe()
+	go r.replay(server)
+	return client, nil
+}
+
+func (r *ReplayDialer) replay(conn *MemoryConn) {
+	done := r.doneChan()
+	defer r.closeOnce.Do(func() { close(done) })
+
+	var last time.Time
+	for _, frame := range r.Frames {
+		if frame.Direction == DirectionOut {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			last = frame.Time
+			continue
+		}
+
+		if !last.IsZero() && r.Speed > 0 {
+			time.Sleep(time.Duration(float64(frame.Time.Sub(last)) / r.Speed))
+		}
+		last = frame.Time
+
+		if err := conn.WriteMessage(websocket.TextMessage, frame.Data); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package flux
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 390.9}))
+
+	var recording bytes.Buffer
+	s, err := New(srv.TdaSession(), WithConfigURL(srv.ConfigURL()), WithLogger(nil),
+		WithHeartbeat(0, 0), WithRecorder(NewRecorder(&recording)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RequestChart(ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if bytes.Contains(recording.Bytes(), []byte(fluxtest.AccessToken)) {
+		t.Fatal("the access token was recorded")
+	}
+
+	frames, err := LoadRecording(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a literal works as well as NewReplayDialer
+	replay := &ReplayDialer{Frames: frames}
+	s, err = New(srv.TdaSession(), WithDialer(replay), WithGatewayURL("replay://"),
+		WithLogger(nil), WithHeartbeat(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	chart, err := s.RequestChart(ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chart.Candles.Closes) != 1 || chart.Candles.Closes[0] != 390.9 {
+		t.Fatalf("unexpected chart %+v", chart)
+	}
+
+	go s.Close()
+	select {
+	case <-replay.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("the replay did not finish")
+	}
+}
@@ -0,0 +1,86 @@
+package flux
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// router hands each payload from the listen loop to the request waiting on
+// it, waiters are registered by request (service and header id) so that
+// concurrent requests never see each other's responses
+type router struct {
+	mu      sync.Mutex
+	waiters map[stateKey]chan storedCache
+}
+
+func newRouter() *router {
+	return &router{
+		waiters: make(map[stateKey]chan storedCache),
+	}
+}
+
+// register returns the channel the payloads for key are delivered on, it has
+// to be called before the request is sent so that no response is missed
+func (r *router) register(key stateKey) <-chan storedCache {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan storedCache, 1)
+	r.waiters[key] = ch
+	return ch
+}
+
+// unregister removes the waiter for key, payloads for it are dropped from then
+// on
+func (r *router) unregister(key stateKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.waiters, key)
+}
+
+// deliver hands the payload to the waiter for key without blocking, if the
+// waiter has not read the previous payload yet it is replaced by this one
+// (the documents are cumulative, so the latest is all the waiter needs)
+func (r *router) deliver(key stateKey, payload storedCache) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.waiters[key]
+	if !ok {
+		return false
+	}
+
+	for {
+		select {
+		case ch <- payload:
+			return true
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// requestFailed logs a request that gave up waiting for its response and
+// returns the error it fails with
+func (s *Session) requestFailed(ctx context.Context, key stateKey, keyvals ...interface{}) error {
+	err := contextError(ctx)
+	s.logger.Debug("request failed", append([]interface{}{"service", key.Service, "id", key.ID, "err", err}, keyvals...)...)
+	return err
+}
+
+// settle waits for d to pass so that patches following a response can be
+// applied, it returns early if ctx is done
+func settle(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
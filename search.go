@@ -2,49 +2,24 @@ package flux
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/Jeffail/gabs/v2"
-	jsonpatch "github.com/evanphx/json-patch"
 )
 
-func (s *Session) searchHandler(msg []byte, patch *gabs.Container) {
-	rID := patch.Search("header", "id").String()
-	rID = rID[1 : len(rID)-1]
-	rService := patch.Search("header", "service").String()
-	rService = rService[1 : len(rService)-1]
-
-	patch = patch.S("body", "patches", "0")
-
-	newSearch := newSearchObject{}
-	json.Unmarshal(patch.Bytes(), &newSearch)
-	newSearch.Path = "/search"
-	newSearch.Value.RequestID = rID
-	newSearch.Value.Service = rService
-	modifiedSearch, err := json.Marshal([]newSearchObject{newSearch})
-	if err != nil {
+func (s *Session) searchHandler(msg []byte, gab *gabs.Container) {
+	key := headerKey(gab)
+	if _, ok := s.state.apply(key, gab); !ok {
 		return
 	}
 
-	jspatch, err := jsonpatch.DecodePatch(modifiedSearch)
-	if err != nil {
-		return
-	}
-
-	byteState, _ := json.Marshal(s.CurrentState)
-
-	byteState, err = jspatch.Apply(byteState)
-	if err != nil {
-		return
-	}
-
-	var newState storedCache
-	json.Unmarshal(byteState, &newState)
+	var state SearchStoredCache
+	s.state.get(key, &state)
+	state.RequestID = key.ID
+	state.Service = key.Service
 
-	s.CurrentState = newState
-	s.TransactionChannel <- s.CurrentState
+	s.router.deliver(key, storedCache{Search: state})
 }
 
 // SearchRequestSignature is the parameter for a search request
@@ -96,19 +71,24 @@ type SearchStoredCache struct {
 	Service    string `json:"service"`
 }
 
-type newSearchObject struct {
-	Op    string            `json:"op"`
-	Path  string            `json:"path"`
-	Value SearchStoredCache `json:"value"`
-}
-
 // RequestSearch takes a SearchRequestSignature as an input and responds with a
-// search query, it does not utilize a cache (although it maintains one) so the
-// query made will be up to date with the servers. If the query is not loaded
-// within a certain time, ErrNotReceivedInTime is sent as an error
+// search query, it does not utilize a cache so the query made will be up to
+// date with the servers. If the query is not loaded within a certain time,
+// ErrNotReceivedInTime is sent as an error
 func (s *Session) RequestSearch(spec SearchRequestSignature) (*SearchStoredCache, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestSearchContext(ctx, spec)
+}
+
+// RequestSearchContext is the same as RequestSearch but honours the deadline
+// and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestSearchContext(ctx context.Context, spec SearchRequestSignature) (search *SearchStoredCache, err error) {
+	defer s.observeRequest("RequestSearch", time.Now(), &err)
 
-	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), s.SearchRequestVers[spec.shortName()])
+	ver := s.versions.next(spec.shortName())
+	uniqueID := fmt.Sprintf("%s-%d", spec.shortName(), ver)
 	spec.UniqueID = uniqueID
 
 	payload := gatewayRequestLoad{
@@ -116,7 +96,7 @@ func (s *Session) RequestSearch(spec SearchRequestSignature) (*SearchStoredCache
 			{
 				Header: gatewayHeader{
 					Service: "instrument_search",
-					Ver:     int(s.SearchRequestVers[spec.shortName()]),
+					Ver:     ver,
 					ID:      spec.UniqueID,
 				},
 				Params: gatewayParams{
@@ -127,37 +107,25 @@ func (s *Session) RequestSearch(spec SearchRequestSignature) (*SearchStoredCache
 		},
 	}
 
-	s.SearchRequestVers[spec.shortName()]++
-	s.sendJSON(payload)
-
-	internalChannel := make(chan storedCache)
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second)
-	defer ctxCancel()
-
-	go func() {
-		for {
-			select {
+	key := stateKey{Service: "instrument_search", ID: spec.UniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+	defer s.state.remove(key)
 
-			case recvPayload := <-s.TransactionChannel:
-				if recvPayload.Search.RequestID == spec.UniqueID {
-					internalChannel <- recvPayload
-					return
-				}
-
-			case <-ctx.Done():
-				return
-
-			}
-		}
-	}()
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
 
 	select {
 
-	case recvPayload := <-internalChannel:
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
 		return &recvPayload.Search, nil
 
 	case <-ctx.Done():
-		return nil, ErrNotReceivedInTime
+		return nil, s.requestFailed(ctx, key, "pattern", spec.Pattern)
 
 	}
 
@@ -0,0 +1,201 @@
+package flux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// ErrUnknownService is returned by RequestService for a service that has not
+// been registered with RegisterService
+var ErrUnknownService = errors.New("error: service is not registered")
+
+// Response is a single response of a service, the patches that arrived in one
+// frame and the request's document once they have been applied
+type Response struct {
+	Service   string
+	RequestID string
+	Patches   []Patch
+	Document  json.RawMessage
+}
+
+// Decode unmarshals the request's document into v
+func (r *Response) Decode(v interface{}) error {
+	return json.Unmarshal(r.Document, v)
+}
+
+// ServiceHandler is called with every response of a service registered with
+// RegisterService, it is called from the goroutine reading the connection so
+// it must not block
+type ServiceHandler func(resp *Response)
+
+// serviceHandler handles a single payload entry of a service
+type serviceHandler func(msg []byte, gab *gabs.Container)
+
+// services maps the services of the payloads received to their handlers
+type services struct {
+	mu        sync.RWMutex
+	handlers  map[string]serviceHandler
+	unhandled func(service string, payload json.RawMessage)
+}
+
+func newServices() *services {
+	return &services{
+		handlers: make(map[string]serviceHandler),
+	}
+}
+
+func (sv *services) handler(service string) (serviceHandler, bool) {
+	sv.mu.RLock()
+	defer sv.mu.RUnlock()
+	handler, ok := sv.handlers[service]
+	return handler, ok
+}
+
+func (sv *services) register(service string, handler serviceHandler) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.handlers[service] = handler
+}
+
+// registerBuiltins registers the handlers of the services flux has requests
+// for
+func (s *Session) registerBuiltins() {
+	s.services.register("login", func(msg []byte, gab *gabs.Container) {
+		// a rejected login on an open connection (after the access token was
+		// refreshed) can only be recovered from by reconnecting
+		if err := s.loginHandler(gab); err != nil {
+			if conn := s.conn(); conn != nil {
+				conn.Close()
+			}
+		}
+	})
+	s.services.register("chart_v27", s.chartHandler)
+	s.services.register("instrument_search", s.searchHandler)
+	s.services.register("instrument_details", s.instrumentDetailsHandler)
+	s.services.register("optionSeries", s.optionSeriesHandler)
+	s.services.register("option_chain/get", s.optionChainGetHandler)
+	s.services.register("quotes", s.quoteHandler)
+	s.services.register("quotes/options", s.optionQuoteHandler)
+}
+
+// RegisterService handles the responses of a service flux has no requests for,
+// the patches are applied to a document per request and handler (which may be
+// nil) is called with each response. Requests are made with RequestService.
+// Registering a service flux already handles replaces its handler, which
+// breaks the Request* methods for that service
+func (s *Session) RegisterService(name string, handler ServiceHandler) {
+	s.services.register(name, func(msg []byte, gab *gabs.Container) {
+		key := headerKey(gab)
+		doc, ok := s.state.apply(key, gab)
+		if !ok {
+			return
+		}
+
+		resp := &Response{
+			Service:   key.Service,
+			RequestID: key.ID,
+			Patches:   responsePatches(gab),
+			Document:  json.RawMessage(doc),
+		}
+		if handler != nil {
+			handler(resp)
+		}
+
+		s.router.deliver(key, storedCache{response: resp})
+	})
+}
+
+// OnUnhandled sets a function that is called with every payload of a service
+// no handler is registered for, payload is the payload entry as received
+// (header and body). It is called from the goroutine reading the connection
+// so it must not block, a nil function drops those payloads (the default)
+func (s *Session) OnUnhandled(fn func(service string, payload json.RawMessage)) {
+	s.services.mu.Lock()
+	defer s.services.mu.Unlock()
+	s.services.unhandled = fn
+}
+
+func (s *Session) unhandledService(service string, gab *gabs.Container) {
+	s.services.mu.RLock()
+	fn := s.services.unhandled
+	s.services.mu.RUnlock()
+
+	if fn == nil {
+		s.logger.Debug("unhandled service", "service", service)
+		return
+	}
+	fn(service, json.RawMessage(gab.Bytes()))
+}
+
+type serviceRequest struct {
+	Header gatewayHeader `json:"header"`
+	Params interface{}   `json:"params"`
+}
+
+type serviceRequestLoad struct {
+	Payload []serviceRequest `json:"payload"`
+}
+
+// RequestService sends a request with the given params (marshalled to JSON) to
+// a service registered with RegisterService and returns its first response,
+// the request's document is dropped once it has been returned
+func (s *Session) RequestService(service string, params interface{}) (*Response, error) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer ctxCancel()
+	return s.RequestServiceContext(ctx, service, params)
+}
+
+// RequestServiceContext is the same as RequestService but honours the
+// deadline and cancellation of ctx, a deadline that passes is reported as
+// ErrNotReceivedInTime
+func (s *Session) RequestServiceContext(ctx context.Context, service string, params interface{}) (resp *Response, err error) {
+	defer s.observeRequest("RequestService", time.Now(), &err)
+
+	if _, ok := s.services.handler(service); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownService, service)
+	}
+
+	ver := s.versions.next(service)
+	uniqueID := fmt.Sprintf("%s-%d", service, ver)
+
+	payload := serviceRequestLoad{
+		Payload: []serviceRequest{
+			{
+				Header: gatewayHeader{
+					Service: service,
+					ID:      uniqueID,
+					Ver:     ver,
+				},
+				Params: params,
+			},
+		},
+	}
+
+	key := stateKey{Service: service, ID: uniqueID}
+	responses := s.router.register(key)
+	defer s.router.unregister(key)
+	defer s.state.remove(key)
+
+	if err := s.sendJSON(payload); err != nil {
+		return nil, err
+	}
+
+	select {
+
+	case recvPayload := <-responses:
+		if recvPayload.err != nil {
+			return nil, recvPayload.err
+		}
+		return recvPayload.response, nil
+
+	case <-ctx.Done():
+		return nil, s.requestFailed(ctx, key)
+
+	}
+}
@@ -0,0 +1,99 @@
+package flux
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestServicePatchesUnchanged(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	responses := make(chan *Response, 2)
+	s.RegisterService("news", func(resp *Response) {
+		responses <- resp
+	})
+
+	header := fluxtest.Header{Service: "news", ID: "news-1", Ver: 1}
+	srv.Push(header, fluxtest.Snapshot(map[string]interface{}{"n": 1.0, "items": []interface{}{"a"}}))
+	srv.Push(header, fluxtest.Replace("/n", 2.0), fluxtest.Add("/items/-", "b"))
+
+	var received []*Response
+	timeout := time.After(time.Second)
+	for len(received) < 2 {
+		select {
+		case resp := <-responses:
+			received = append(received, resp)
+		case <-timeout:
+			t.Fatalf("received %d of 2 responses", len(received))
+		}
+	}
+
+	// the later patches are applied to the document, not to the value of the
+	// snapshot handed out with the first response
+	snapshot, ok := received[0].Patches[0].Value.(map[string]interface{})
+	if !ok || snapshot["n"] != 1.0 || len(snapshot["items"].([]interface{})) != 1 {
+		t.Fatalf("the first response's patch was changed to %+v", received[0].Patches[0].Value)
+	}
+
+	var doc struct {
+		N     float64
+		Items []string
+	}
+	if err := received[1].Decode(&doc); err != nil || doc.N != 2 || len(doc.Items) != 2 {
+		t.Fatalf("unexpected document %s (%v)", received[1].Document, err)
+	}
+}
+
+func TestOnUnhandled(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	type unhandled struct {
+		service string
+		payload json.RawMessage
+	}
+	payloads := make(chan unhandled, 1)
+	s.OnUnhandled(func(service string, payload json.RawMessage) {
+		payloads <- unhandled{service, payload}
+	})
+
+	srv.Push(fluxtest.Header{Service: "news", ID: "news-1", Ver: 1}, fluxtest.Snapshot(map[string]interface{}{"n": 1.0}))
+	select {
+	case got := <-payloads:
+		var entry struct {
+			Header fluxtest.Header
+			Body   struct{ Patches []fluxtest.Patch }
+		}
+		if err := json.Unmarshal(got.payload, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if got.service != "news" || entry.Header.ID != "news-1" || len(entry.Body.Patches) != 1 {
+			t.Fatalf("unexpected payload for %s: %s", got.service, got.payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the unhandled payload was not passed on")
+	}
+
+	// services with a handler are not passed on, nor is anything once the
+	// function is removed
+	s.RegisterService("news", nil)
+	srv.Push(fluxtest.Header{Service: "news", ID: "news-2", Ver: 2}, fluxtest.Snapshot(map[string]interface{}{}))
+	s.OnUnhandled(nil)
+	srv.Push(fluxtest.Header{Service: "alerts", ID: "alerts-1", Ver: 1}, fluxtest.Snapshot(map[string]interface{}{}))
+
+	select {
+	case got := <-payloads:
+		t.Fatalf("unexpected payload for %s: %s", got.service, got.payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -2,46 +2,71 @@ package flux
 
 import (
 	"encoding/json"
-	"hash/fnv"
 	"sync"
+	"time"
 
 	"github.com/adityaxdiwakar/tda-go"
-	"github.com/gorilla/websocket"
 )
 
 // Session is the session object for the flux driver and can be created and
-// returned using flux.New()
+// returned using flux.New(), it is configured with options and its exported
+// fields must not be changed once New returns
 type Session struct {
-	TdaSession                tda.Session
-	wsConn                    *websocket.Conn
-	ConfigURL                 string
-	CurrentState              storedCache
-	TransactionChannel        chan storedCache
-	NotificationChannel       chan bool
-	ChartRequestVers          map[string]int
-	QuoteRequestVers          map[string]int
-	SearchRequestVers         map[string]int
-	OptionSeriesRequestVers   map[string]int
-	OptionChainGetRequestVers map[string]int
-	OptionQuoteRequestVers    map[string]int
-	Mu                        sync.Mutex
-	QuoteMu                   sync.Mutex
-	MutexLock                 bool
-	HandlerWorking            bool
-	DebugFlag                 bool
-	Established               bool
+	TdaSession         tda.Session
+	wsConn             Conn
+	dialer             Dialer
+	ConfigURL          string
+	GatewayURL         string
+	Environment        Environment
+	ProtocolVersion    string
+	state              *stateStore
+	router             *router
+	subscriptions      *subscriptions
+	services           *services
+	versions           *requestVersions
+	writeMu            sync.Mutex
+	debug              bool
+	logger             Logger
+	metrics            Metrics
+	recorder           *Recorder
+	requestTimeout     time.Duration
+	reconnectPolicy    ReconnectPolicy
+	tokenLifetime      time.Duration
+	tokenRefreshAhead  time.Duration
+	tokens             *tokenManager
+	heartbeatInterval  time.Duration
+	heartbeatTolerance int
+	lastHeartbeat      time.Time
+	eventStream        *eventStream
+	connMu             sync.Mutex
+	shutdown           bool
+	serverInfo         ServerInfo
+	err                error
+	opening            bool
+	established        bool
 }
 
-func (s *Session) specHash(str string) int {
-	h := fnv.New32a()
-	h.Write([]byte(str))
-	return int(h.Sum32()) - (1<<31 - 1)
+// ServerInfo describes the gateway a Session is connected to, as reported
+// when the protocol is agreed on
+type ServerInfo struct {
+	Gateway string
+	Session string
+	Build   string
+	Ver     string
 }
 
-// Gateway returns the gateway URL as a string for the live trading connection
-// to be made
+// ServerInfo returns the details of the gateway of the current (or last)
+// connection, it is empty until the Session has been opened
+func (s *Session) ServerInfo() ServerInfo {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.serverInfo
+}
+
+// Gateway returns the gateway URL as a string for the session's Environment,
+// it is retrieved from the session's ConfigURL
 func (s *Session) Gateway() (string, error) {
-	res, err := s.TdaSession.HttpClient.Get("https://trade.thinkorswim.com/v1/api/config")
+	res, err := s.TdaSession.HttpClient.Get(s.ConfigURL)
 	if err != nil {
 		return "", err
 	}
@@ -55,5 +80,10 @@ func (s *Session) Gateway() (string, error) {
 	var gatewayResponse gatewayConfigResponse
 
 	json.NewDecoder(res.Body).Decode(&gatewayResponse)
-	return gatewayResponse.MobileGatewayURL.Livetrading, nil
+
+	gateway := s.Environment.gateway(gatewayResponse)
+	if gateway == "" {
+		return "", ErrGatewayUnsuccessful
+	}
+	return gateway, nil
 }
@@ -0,0 +1,284 @@
+package flux
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+// newTestSession returns an open Session connected to srv that reconnects
+// quickly
+func newTestSession(t *testing.T, srv *fluxtest.Server, opts ...Option) *Session {
+	t.Helper()
+
+	opts = append([]Option{
+		WithConfigURL(srv.ConfigURL()),
+		WithLogger(nil),
+		WithRequestTimeout(2 * time.Second),
+		WithReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond, Multiplier: 1}),
+	}, opts...)
+
+	s, err := New(srv.TdaSession(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	symbols := []string{"AAPL", "MSFT", "TSLA", "AMZN", "GOOG", "NFLX", "NVDA", "AMD"}
+	values := map[string]map[string]interface{}{}
+	for i, symbol := range symbols {
+		values[symbol] = map[string]interface{}{"LAST": float64(100 + i)}
+	}
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}))
+	srv.Handle("quotes", fluxtest.Quotes(values))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(symbols))
+	for i, symbol := range symbols {
+		wg.Add(2)
+
+		go func(symbol string) {
+			defer wg.Done()
+			chart, err := s.RequestChart(ChartRequestSignature{Ticker: symbol, Range: "DAY1", Width: "HOUR1"})
+			if err != nil {
+				errs <- err
+			} else if chart.Symbol != symbol {
+				errs <- fmt.Errorf("requested the %s chart, got %s", symbol, chart.Symbol)
+			}
+		}(symbol)
+
+		go func(symbol string, last float64) {
+			defer wg.Done()
+			quote, err := s.RequestQuote(QuoteRequestSignature{Ticker: symbol, Fields: []QuoteField{Last}})
+			if err != nil {
+				errs <- err
+			} else if quote.Items[0].Symbol != symbol || quote.Items[0].Values.LAST != last {
+				errs <- fmt.Errorf("requested the %s quote, got %+v", symbol, quote.Items[0])
+			}
+		}(symbol, float64(100+i))
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentRequestsSameSpec(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	// quotes requests go unanswered until a response is pushed for one
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	spec := QuoteRequestSignature{Ticker: "AAPL", Fields: []QuoteField{Last}}
+	first := make(chan error, 1)
+	go func() {
+		quote, err := s.RequestQuote(spec)
+		if err == nil && quote.Items[0].Values.LAST != 390.9 {
+			err = fmt.Errorf("unexpected quote %+v", quote.Items[0])
+		}
+		first <- err
+	}()
+	req, ok := srv.WaitRequest("quotes", 0, time.Second)
+	if !ok {
+		t.Fatal("the first request was not sent")
+	}
+
+	// a second request for the same spec that is never answered
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.RequestQuoteContext(ctx, spec); err != ErrNotReceivedInTime {
+		t.Fatalf("expected ErrNotReceivedInTime, got %v", err)
+	}
+
+	quotes := fluxtest.Quotes(map[string]map[string]interface{}{"AAPL": {"LAST": 390.9}})
+	srv.Push(req.Header, quotes(req)...)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeDuringReconnect(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}))
+	srv.Handle("quotes", fluxtest.Quotes(map[string]map[string]interface{}{"AAPL": {"LAST": 390.9}}))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+	events := s.Events()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				updates, cancel := s.SubscribeChart(ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "MIN1"})
+				quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+				quotes.Add("MSFT")
+
+				if i == 0 && j == 5 {
+					srv.Disconnect()
+				}
+
+				cancel()
+				quotes.Cancel()
+				for range updates {
+				}
+				for range quotes.Updates {
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// once reconnected the session serves requests again
+	disconnected := false
+	timeout := time.After(2 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case Disconnected:
+				disconnected = true
+			case LoggedIn:
+				reconnected = disconnected
+			}
+		case <-timeout:
+			t.Fatal("the session did not reconnect")
+		}
+	}
+	if _, err := s.RequestChart(ChartRequestSignature{Ticker: "MSFT", Range: "DAY1", Width: "HOUR1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.subscriptions.mu.Lock()
+	defer s.subscriptions.mu.Unlock()
+	if len(s.subscriptions.subs) != 0 {
+		t.Fatalf("%d subscriptions were left after cancelling", len(s.subscriptions.subs))
+	}
+}
+
+func TestSubscriptionsSurviveReconnect(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("chart_v27", fluxtest.Chart(fluxtest.Candle{Timestamp: 1595260800000, Close: 1}))
+	srv.Handle("quotes", fluxtest.Quotes(map[string]map[string]interface{}{"AAPL": {"LAST": 390.9}}))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+	events := s.Events()
+
+	chart, cancel := s.SubscribeChart(ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "MIN1"})
+	defer cancel()
+	quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+	defer quotes.Cancel()
+
+	select {
+	case <-quotes.Updates:
+	case <-time.After(time.Second):
+		t.Fatal("no quote was received")
+	}
+
+	srv.Disconnect()
+	timeout := time.After(2 * time.Second)
+	for disconnected, reconnected := false, false; !reconnected; {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case Disconnected:
+				disconnected = true
+			case LoggedIn:
+				reconnected = disconnected
+			}
+		case <-timeout:
+			t.Fatal("the session did not reconnect")
+		}
+	}
+
+	// each subscription is requested once more, on the new connection
+	chartReq, ok := srv.WaitRequest("chart_v27", 1, time.Second)
+	if !ok {
+		t.Fatal("the chart subscription was not resent")
+	}
+	quoteReq, ok := srv.WaitRequest("quotes", 1, time.Second)
+	if !ok {
+		t.Fatal("the quote subscription was not resent")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n, m := len(srv.Requests("chart_v27")), len(srv.Requests("quotes")); n != 2 || m != 2 {
+		t.Fatalf("expected each subscription to be sent twice, got %d chart and %d quotes requests", n, m)
+	}
+
+	srv.Push(chartReq.Header, fluxtest.Replace("/candles/closes/0", 2.0))
+	select {
+	case update := <-chart:
+		if update.Err != nil || update.Candle.Close != 2 {
+			t.Fatalf("unexpected chart update %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no chart update was received after reconnecting")
+	}
+
+	srv.Push(quoteReq.Header, fluxtest.Replace("/items/0/values/LAST", 391.5))
+	for {
+		select {
+		case update := <-quotes.Updates:
+			if update.Err != nil {
+				t.Fatal(update.Err)
+			}
+			if update.Value == 391.5 {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no quote update was received after reconnecting")
+		}
+	}
+}
+
+func TestCloseDuringReconnect(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithReconnectPolicy(ReconnectPolicy{
+		InitialDelay: 50 * time.Millisecond,
+		Multiplier:   1,
+	}))
+	events := s.Events()
+
+	srv.Disconnect()
+	for e := range events {
+		if _, ok := e.(Reconnecting); ok {
+			break
+		}
+	}
+	s.Close()
+
+	// the attempt that was waiting must not bring the connection back
+	time.Sleep(200 * time.Millisecond)
+	if s.Established() || srv.Connections() != 0 {
+		t.Fatal("the session reconnected after it was closed")
+	}
+	if _, err := s.RequestSearch(SearchRequestSignature{Pattern: "AAPL"}); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build go1.21
+// +build go1.21
+
+package flux
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := NewSlogLogger(slog.New(handler))
+
+	tests := []struct {
+		log  func()
+		want string
+	}{
+		{func() { logger.Debug("received", "frame", "{}") }, `level=DEBUG msg=received frame={}` + "\n"},
+		{func() { logger.Info("logged in") }, "level=INFO msg=\"logged in\"\n"},
+		{func() { logger.Warn("reconnecting", "attempt", 2) }, "level=WARN msg=reconnecting attempt=2\n"},
+		{func() { logger.Error("login failed", "reason", "expired") }, "level=ERROR msg=\"login failed\" reason=expired\n"},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		tt.log()
+		if buf.String() != tt.want {
+			t.Fatalf("expected %q, got %q", tt.want, buf.String())
+		}
+	}
+}
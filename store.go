@@ -0,0 +1,174 @@
+package flux
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/Jeffail/gabs/v2"
+)
+
+// stateKey identifies the document held for a single request, the provisioner
+// patches documents by service and request id
+type stateKey struct {
+	Service string
+	ID      string
+}
+
+// stateStore holds one JSON document per request, patches for a request are
+// only ever applied to that request's document
+type stateStore struct {
+	mu      sync.RWMutex
+	docs    map[stateKey]*document
+	metrics Metrics
+}
+
+// document is kept decoded so that patches are applied in place, it is only
+// marshalled once per batch of patches
+type document struct {
+	tree interface{}
+	raw  []byte
+}
+
+func newStateStore(metrics Metrics) *stateStore {
+	return &stateStore{
+		docs:    make(map[stateKey]*document),
+		metrics: metrics,
+	}
+}
+
+// headerKey reads the state key from the header of a payload entry
+func headerKey(gab *gabs.Container) stateKey {
+	service, _ := gab.Search("header", "service").Data().(string)
+	id, _ := gab.Search("header", "id").Data().(string)
+	return stateKey{Service: service, ID: id}
+}
+
+// serviceError returns the error in a response's /error patch, or nil if the
+// response has none
+func serviceError(gab *gabs.Container) *ServiceError {
+	for _, patch := range gab.S("body", "patches").Children() {
+		if path, _ := patch.S("path").Data().(string); path != "/error" {
+			continue
+		}
+
+		key := headerKey(gab)
+		err := &ServiceError{Service: key.Service, RequestID: key.ID}
+
+		switch code := patch.S("value", "code").Data().(type) {
+		case float64:
+			err.Code = int(code)
+		case string:
+			err.Code, _ = strconv.Atoi(code)
+		}
+
+		switch message := patch.S("value", "message").Data().(type) {
+		case string:
+			err.Message = message
+		case nil:
+		default:
+			err.Message = patch.S("value", "message").String()
+		}
+		return err
+	}
+	return nil
+}
+
+// apply applies the patches of a payload entry to the document for key and
+// returns the updated document, patches that cannot be applied are skipped
+func (st *stateStore) apply(key stateKey, gab *gabs.Container) ([]byte, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	applied, failed := 0, 0
+	defer func() {
+		if applied+failed > 0 {
+			st.metrics.PatchesApplied(key.Service, applied, failed)
+		}
+	}()
+
+	var tree interface{}
+	doc, ok := st.docs[key]
+	if ok {
+		tree = doc.tree
+	}
+
+	for _, op := range responsePatches(gab) {
+		if op.Path == "/error" {
+			continue
+		}
+
+		// a patch on the root replaces the whole document, with a copy of the
+		// value since the patch is handed out with the response
+		if op.Path == "" && (op.Op == "add" || op.Op == "replace") {
+			tree = deepCopy(op.Value)
+			ok = true
+			applied++
+			continue
+		}
+
+		if !ok {
+			failed++
+			continue
+		}
+
+		patched, err := applyPatch(tree, op)
+		if err != nil {
+			failed++
+			continue
+		}
+		tree = patched
+		applied++
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	if doc == nil || applied > 0 {
+		raw, err := json.Marshal(tree)
+		if err != nil {
+			return nil, false
+		}
+		doc = &document{tree: tree, raw: raw}
+		st.docs[key] = doc
+	}
+	return doc.raw, true
+}
+
+// get unmarshals the document for key into v, returning false if there is no
+// document for the key
+func (st *stateStore) get(key stateKey, v interface{}) bool {
+	st.mu.RLock()
+	doc, ok := st.docs[key]
+	st.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(doc.raw, v) == nil
+}
+
+// remove drops the document for key
+func (st *stateStore) remove(key stateKey) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.docs, key)
+}
+
+// retain drops every document except those for keys, documents that are kept
+// are replaced once their requests are resent
+func (st *stateStore) retain(keys []stateKey) {
+	keep := make(map[stateKey]bool)
+	for _, key := range keys {
+		keep[key] = true
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for key := range st.docs {
+		if !keep[key] {
+			delete(st.docs, key)
+		}
+	}
+}
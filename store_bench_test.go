@@ -0,0 +1,177 @@
+package flux
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/adityaxdiwakar/flux/fluxtest"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// The benchmarks compare applying a frame of quote or chart patches the way
+// the handlers used to (every patch decoded and applied to the marshalled
+// document with json-patch, then the document unmarshalled) with the
+// stateStore, both are fed the same snapshot and frame
+
+const (
+	benchSymbols      = 100
+	benchFields       = 10
+	benchQuotePatches = 50
+	benchCandles      = 2000
+)
+
+var benchQuoteFields = []QuoteField{
+	Last, Bid, Ask, BidSize, AskSize, Volume, High, Low, Open, Mark,
+}
+
+func benchSymbol(i int) string {
+	return fmt.Sprintf("SYM%03d", i)
+}
+
+// benchQuotes returns a snapshot of benchSymbols quotes and a frame that
+// changes a value of every other symbol
+func benchQuotes() ([]byte, []byte) {
+	items := []map[string]interface{}{}
+	for i := 0; i < benchSymbols; i++ {
+		values := map[string]interface{}{}
+		for _, field := range benchQuoteFields[:benchFields] {
+			values[string(field)] = float64(i)
+		}
+		items = append(items, map[string]interface{}{"symbol": benchSymbol(i), "values": values})
+	}
+	snapshot, _ := json.Marshal(map[string]interface{}{"items": items})
+
+	patches := []fluxtest.Patch{}
+	for i := 0; i < benchQuotePatches; i++ {
+		path := fmt.Sprintf("/items/%d/values/%s", (i*2)%benchSymbols, benchQuoteFields[i%benchFields])
+		patches = append(patches, fluxtest.Replace(path, float64(i)))
+	}
+	return snapshot, benchFrame(fluxtest.Header{Service: "quotes", ID: "QUOTES-0"}, patches)
+}
+
+// benchChart returns a snapshot of a chart of benchCandles candles and a frame
+// that updates its last candle
+func benchChart() ([]byte, []byte) {
+	bars := make([]fluxtest.Candle, benchCandles)
+	for i := range bars {
+		bars[i] = fluxtest.Candle{Timestamp: 1595260800000 + int64(i)*60000, Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 100}
+	}
+	snapshot, _ := json.Marshal(fluxtest.ChartValue("AAPL", bars...))
+
+	last := benchCandles - 1
+	patches := []fluxtest.Patch{
+		fluxtest.Replace(fmt.Sprintf("/candles/closes/%d", last), 1.55),
+		fluxtest.Replace(fmt.Sprintf("/candles/highs/%d", last), 2.05),
+		fluxtest.Replace(fmt.Sprintf("/candles/volumes/%d", last), 150.0),
+	}
+	return snapshot, benchFrame(fluxtest.Header{Service: "chart_v27", ID: "CHART-0"}, patches)
+}
+
+func benchFrame(header fluxtest.Header, patches []fluxtest.Patch) []byte {
+	message, _ := json.Marshal(map[string]interface{}{
+		"payload": []interface{}{
+			map[string]interface{}{
+				"header": header,
+				"body":   map[string]interface{}{"patches": patches},
+			},
+		},
+	})
+	return message
+}
+
+// benchmarkJSONPatch applies every patch of the frame with json-patch, then
+// unmarshals the document into v
+func benchmarkJSONPatch(b *testing.B, snapshot, message []byte, v interface{}) {
+	doc := snapshot
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		parsed, err := gabs.ParseJSON(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, child := range parsed.S("payload").Children() {
+			for _, patch := range child.S("body", "patches").Children() {
+				jspatch, err := jsonpatch.DecodePatch([]byte("[" + patch.String() + "]"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				doc, err = jspatch.Apply(doc)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+
+		if err := json.Unmarshal(doc, v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkStore applies the frame with stateStore.apply, then unmarshals the
+// document into v as the handlers do
+func benchmarkStore(b *testing.B, snapshot, message []byte, v interface{}) {
+	var value interface{}
+	if err := json.Unmarshal(snapshot, &value); err != nil {
+		b.Fatal(err)
+	}
+
+	// the snapshot arrives as a patch on the root, under the frame's header
+	snapshotFrame, err := gabs.ParseJSON(message)
+	if err != nil {
+		b.Fatal(err)
+	}
+	entry := snapshotFrame.S("payload").Index(0)
+	entry.Set([]interface{}{map[string]interface{}{"op": "replace", "path": "", "value": value}}, "body", "patches")
+
+	st := newStateStore(nopMetrics{})
+	key := headerKey(entry)
+	if _, ok := st.apply(key, entry); !ok {
+		b.Fatal("the snapshot was not applied")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		parsed, err := gabs.ParseJSON(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, child := range parsed.S("payload").Children() {
+			if _, ok := st.apply(key, child); !ok {
+				b.Fatal("the frame was not applied")
+			}
+		}
+
+		if !st.get(key, v) {
+			b.Fatal("no document")
+		}
+	}
+}
+
+func BenchmarkQuotesJSONPatch(b *testing.B) {
+	snapshot, message := benchQuotes()
+	benchmarkJSONPatch(b, snapshot, message, &QuoteStoredCache{})
+}
+
+func BenchmarkQuotesStore(b *testing.B) {
+	snapshot, message := benchQuotes()
+	benchmarkStore(b, snapshot, message, &QuoteStoredCache{})
+}
+
+func BenchmarkChartJSONPatch(b *testing.B) {
+	snapshot, message := benchChart()
+	benchmarkJSONPatch(b, snapshot, message, &ChartStoredCache{})
+}
+
+func BenchmarkChartStore(b *testing.B) {
+	snapshot, message := benchChart()
+	benchmarkStore(b, snapshot, message, &ChartStoredCache{})
+}
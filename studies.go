@@ -0,0 +1,59 @@
+package flux
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChartStudy is a study for the provisioner to compute alongside the candles
+// of a chart, such as a moving average. Studies are named as they are in
+// thinkorswim
+type ChartStudy struct {
+	// Name of the study, such as "SimpleMovingAvg"
+	Name string
+
+	// Inputs of the study in the order thinkorswim lists them, they can be
+	// left out to use the study's defaults
+	Inputs []string
+}
+
+// String formats the study as it is sent to the provisioner, the name followed
+// by the inputs in parentheses (if there are any)
+func (st ChartStudy) String() string {
+	if len(st.Inputs) == 0 {
+		return st.Name
+	}
+	return fmt.Sprintf("%s(%s)", st.Name, strings.Join(st.Inputs, ","))
+}
+
+// SimpleMovingAverage is the simple moving average of the closes over length
+// candles
+func SimpleMovingAverage(length int) ChartStudy {
+	return ChartStudy{Name: "SimpleMovingAvg", Inputs: []string{"close", strconv.Itoa(length)}}
+}
+
+// ExponentialMovingAverage is the exponential moving average of the closes
+// over length candles
+func ExponentialMovingAverage(length int) ChartStudy {
+	return ChartStudy{Name: "MovAvgExponential", Inputs: []string{"close", strconv.Itoa(length)}}
+}
+
+// VWAPStudy is the volume weighted average price with its default bands
+func VWAPStudy() ChartStudy {
+	return ChartStudy{Name: "VWAP"}
+}
+
+// VolumeProfileStudy is the volume profile with its default settings
+func VolumeProfileStudy() ChartStudy {
+	return ChartStudy{Name: "VolumeProfile"}
+}
+
+// studyNames formats studies for a chart request
+func studyNames(studies []ChartStudy) []string {
+	names := []string{}
+	for _, study := range studies {
+		names = append(names, study.String())
+	}
+	return names
+}
@@ -0,0 +1,226 @@
+package flux
+
+import "sync"
+
+// subscription is a request whose document keeps being patched after the
+// first response, the handler for its service pushes typed events onto the
+// subscription's queue
+type subscription struct {
+	key    stateKey
+	events *queue
+
+	mu      sync.Mutex
+	request gatewayRequest
+}
+
+// setRequest records the request that (re)creates the subscription, this is
+// what is resent after a reconnect
+func (sub *subscription) setRequest(request gatewayRequest) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.request = request
+}
+
+func (sub *subscription) getRequest() gatewayRequest {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.request
+}
+
+// subscriptions is the registry of active subscriptions keyed by request
+type subscriptions struct {
+	mu   sync.Mutex
+	subs map[stateKey]*subscription
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		subs: make(map[stateKey]*subscription),
+	}
+}
+
+func (r *subscriptions) add(sub *subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs[sub.key] = sub
+}
+
+func (r *subscriptions) get(key stateKey) (*subscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sub, ok := r.subs[key]
+	return sub, ok
+}
+
+func (r *subscriptions) remove(key stateKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subs, key)
+}
+
+// keys returns the keys of every active subscription
+func (r *subscriptions) keys() []stateKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := []stateKey{}
+	for key := range r.subs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// requests returns the request of every active subscription
+func (r *subscriptions) requests() []gatewayRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requests := []gatewayRequest{}
+	for _, sub := range r.subs {
+		// the request is only missing if it is about to be sent for the
+		// first time
+		if request := sub.getRequest(); request.Header.Service != "" {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// restoreSubscriptions resends the request of every active subscription, Open
+// calls it (with s.writeMu held) after logging in so that subscriptions survive a
+// reconnect. The snapshots the provisioner responds with replace the stale
+// documents, and the handlers compare the two to send whatever changed in the
+// meantime
+func (s *Session) restoreSubscriptions(conn Conn) error {
+	requests := s.subscriptions.requests()
+	if len(requests) == 0 {
+		return nil
+	}
+
+	payload := gatewayRequestLoad{Payload: requests}
+	s.recorder.recordJSON(DirectionOut, payload)
+	return conn.WriteJSON(payload)
+}
+
+// sendSubscription records the request of a subscription and sends it, both
+// under the write lock so that a reconnect happening meanwhile either
+// restores the request or hands over the connection it is sent on
+func (s *Session) sendSubscription(sub *subscription, request gatewayRequest) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	sub.setRequest(request)
+	return s.writeJSON(gatewayRequestLoad{
+		Payload: []gatewayRequest{request},
+	})
+}
+
+// subscribe registers a subscription for the request with key, it has to be
+// called before the request is sent so that no patch is missed
+func (s *Session) subscribe(key stateKey) *subscription {
+	sub := &subscription{
+		key: key,
+		events: newQueue(func(delta int) {
+			s.metrics.QueueDepthChanged(key.Service, delta)
+		}),
+	}
+	s.subscriptions.add(sub)
+	return sub
+}
+
+// unsubscribe stops delivering events for a subscription and drops its
+// document, it is safe to call more than once
+func (s *Session) unsubscribe(sub *subscription) {
+	s.subscriptions.remove(sub.key)
+	s.state.remove(sub.key)
+	sub.events.close()
+}
+
+// queue is an unbounded FIFO that never blocks the pusher, so the listen loop
+// cannot be stalled by a subscriber that is slow to read its events
+type queue struct {
+	mu      sync.Mutex
+	items   []interface{}
+	pending int
+	depth   func(delta int)
+	signal  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newQueue returns an empty queue, depth is told every time values are
+// queued or handed on
+func newQueue(depth func(delta int)) *queue {
+	return &queue{
+		depth:  depth,
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// push queues v, values pushed after the queue is closed are dropped
+func (q *queue) push(v interface{}) {
+	q.mu.Lock()
+	select {
+	case <-q.done:
+		q.mu.Unlock()
+		return
+	default:
+	}
+	q.items = append(q.items, v)
+	q.pending++
+	q.mu.Unlock()
+	q.depth(1)
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) close() {
+	q.once.Do(func() {
+		close(q.done)
+
+		// whatever was never handed on is dropped
+		q.mu.Lock()
+		pending := q.pending
+		q.pending = 0
+		q.items = nil
+		q.mu.Unlock()
+		if pending > 0 {
+			q.depth(-pending)
+		}
+	})
+}
+
+// drain hands every queued value to send, in order, until the queue is
+// closed; send should give up when the done channel it is passed is closed
+func (q *queue) drain(send func(v interface{}, done <-chan struct{})) {
+	for {
+		q.mu.Lock()
+		items := q.items
+		q.items = nil
+		q.mu.Unlock()
+
+		for _, v := range items {
+			send(v, q.done)
+
+			q.mu.Lock()
+			handed := q.pending > 0
+			if handed {
+				q.pending--
+			}
+			q.mu.Unlock()
+			if handed {
+				q.depth(-1)
+			}
+		}
+
+		select {
+		case <-q.signal:
+		case <-q.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package flux
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestSubscribeChartServiceError(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"chart_v27": fluxtest.Reply(fluxtest.Error(404, "symbol not found")),
+	})
+
+	updates, cancel := s.SubscribeChart(ChartRequestSignature{Ticker: "BADSYM", Range: "DAY1", Width: "HOUR1"})
+	defer cancel()
+
+	select {
+	case update := <-updates:
+		var serviceErr *ServiceError
+		if !errors.As(update.Err, &serviceErr) || serviceErr.Code != 404 {
+			t.Fatalf("expected a ServiceError, got %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error was not sent on the subscription")
+	}
+}
+
+func TestSubscribeQuotesServiceError(t *testing.T) {
+	s := newMemorySession(t, map[string]fluxtest.Responder{
+		"quotes": func(req fluxtest.Request) []fluxtest.Patch {
+			var symbols []string
+			req.Param("symbols", &symbols)
+			for _, symbol := range symbols {
+				if symbol == "BADSYM" {
+					return []fluxtest.Patch{fluxtest.Error(404, "symbol not found")}
+				}
+			}
+			return fluxtest.Quotes(map[string]map[string]interface{}{"AAPL": {"LAST": 390.9}})(req)
+		},
+	})
+
+	quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+	defer quotes.Cancel()
+
+	select {
+	case update := <-quotes.Updates:
+		if update.Err != nil || update.Symbol != "AAPL" {
+			t.Fatalf("unexpected update %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no quote was received")
+	}
+
+	quotes.Add("BADSYM")
+	select {
+	case update := <-quotes.Updates:
+		var serviceErr *ServiceError
+		if !errors.As(update.Err, &serviceErr) {
+			t.Fatalf("expected a ServiceError, got %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error was not sent on the subscription")
+	}
+}
+
+func TestSubscribeSendError(t *testing.T) {
+	s := newMemorySession(t, nil)
+	s.Close()
+
+	updates, cancel := s.SubscribeChart(ChartRequestSignature{Ticker: "AAPL", Range: "DAY1", Width: "HOUR1"})
+	defer cancel()
+
+	select {
+	case update := <-updates:
+		if update.Err != ErrConnClosed {
+			t.Fatalf("expected ErrConnClosed, got %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error was not sent on the subscription")
+	}
+
+	quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+	defer quotes.Cancel()
+
+	select {
+	case update := <-quotes.Updates:
+		if update.Err != ErrConnClosed {
+			t.Fatalf("expected ErrConnClosed, got %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error was not sent on the subscription")
+	}
+}
+
+// depthMetrics keeps the total depth of the subscription queues
+type depthMetrics struct {
+	nopMetrics
+
+	mu    sync.Mutex
+	depth int
+}
+
+func (m *depthMetrics) QueueDepthChanged(queue string, delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depth += delta
+}
+
+func TestQuoteSubscriptionCancelled(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+	srv.Handle("quotes", fluxtest.Quotes(map[string]map[string]interface{}{"AAPL": {"LAST": 390.9}}))
+
+	s := newTestSession(t, srv)
+	defer s.Close()
+
+	quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+	select {
+	case <-quotes.Updates:
+	case <-time.After(time.Second):
+		t.Fatal("no quote was received")
+	}
+	quotes.Cancel()
+	for range quotes.Updates {
+	}
+
+	sent := len(srv.Requests("quotes"))
+	quotes.Add("MSFT")
+	quotes.Remove("AAPL")
+	time.Sleep(100 * time.Millisecond)
+
+	if len(srv.Requests("quotes")) != sent {
+		t.Fatal("a request was sent after the subscription was cancelled")
+	}
+	if symbols := quotes.Symbols(); len(symbols) != 1 || symbols[0] != "AAPL" {
+		t.Fatalf("the symbols changed after the subscription was cancelled: %v", symbols)
+	}
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	if len(s.state.docs) != 0 {
+		t.Fatalf("%d documents were kept", len(s.state.docs))
+	}
+}
+
+func TestQuoteSubscriptionCancelledSendError(t *testing.T) {
+	metrics := &depthMetrics{}
+	s := newMemorySession(t, nil, WithMetrics(metrics))
+	s.Close()
+
+	quotes := s.SubscribeQuotes([]string{"AAPL"}, []QuoteField{Last})
+	if update := <-quotes.Updates; update.Err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, got %+v", update)
+	}
+	quotes.Cancel()
+	for range quotes.Updates {
+	}
+	quotes.Add("MSFT")
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.depth != 0 {
+		t.Fatalf("the queue depth was left at %d", metrics.depth)
+	}
+}
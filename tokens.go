@@ -0,0 +1,98 @@
+package flux
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenManager keeps the access token a Session logs in with, tda-go does not
+// report how long a token lasts so it is assumed to expire TokenLifetime after
+// it was fetched
+type tokenManager struct {
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// accessToken returns the current access token, fetching a new one if it is
+// within TokenRefreshAhead of expiring
+func (s *Session) accessToken() (string, error) {
+	t := s.tokens
+	t.mu.Lock()
+	token, fetched := t.token, t.fetched
+	t.mu.Unlock()
+
+	if token != "" && time.Until(fetched.Add(s.tokenLifetime)) > s.tokenRefreshAhead {
+		return token, nil
+	}
+	return s.refreshAccessToken()
+}
+
+// refreshAccessToken fetches a new access token regardless of the current one
+func (s *Session) refreshAccessToken() (string, error) {
+	token, err := s.TdaSession.GetAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	t := s.tokens
+	t.mu.Lock()
+	t.token = token
+	t.fetched = time.Now()
+	t.mu.Unlock()
+
+	return token, nil
+}
+
+// refreshAt is when the current access token should be replaced, which is
+// TokenRefreshAhead before it expires (or halfway through its life if the
+// lifetime is shorter than that)
+func (s *Session) refreshAt() time.Time {
+	t := s.tokens
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ahead := s.tokenRefreshAhead
+	if ahead <= 0 || ahead >= s.tokenLifetime {
+		ahead = s.tokenLifetime / 2
+	}
+	return t.fetched.Add(s.tokenLifetime - ahead)
+}
+
+// refreshTokens fetches a new access token ahead of the current one expiring
+// and logs in with it again on the open connection, so the connection does
+// not have to be dropped. Failed fetches are retried following the
+// ReconnectPolicy, a rejected login is handled by the listen loop
+func (s *Session) refreshTokens(conn Conn, done <-chan struct{}) {
+	if s.tokenLifetime <= 0 {
+		return
+	}
+
+	wait := time.Until(s.refreshAt())
+	for attempt := 1; ; {
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		token, err := s.refreshAccessToken()
+		if err != nil {
+			wait = s.reconnectPolicy.Delay(attempt)
+			s.logger.Warn("could not refresh access token", "retry", wait, "err", err)
+			attempt++
+			continue
+		}
+		attempt = 1
+
+		s.logger.Info("refreshed access token, logging in again")
+		if err := s.sendLogin(conn, token); err != nil {
+			// the connection is gone, listen takes care of reconnecting
+			return
+		}
+
+		wait = time.Until(s.refreshAt())
+	}
+}
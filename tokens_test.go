@@ -0,0 +1,88 @@
+package flux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adityaxdiwakar/flux/fluxtest"
+)
+
+func TestTokenRefreshLogsInAgain(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithTokenRefresh(200*time.Millisecond, 100*time.Millisecond))
+	defer s.Close()
+	events := s.Events()
+
+	srv.SetAccessToken("fluxtest-fresh-token")
+	login, ok := srv.WaitRequest("login", 1, time.Second)
+	if !ok {
+		t.Fatal("the session did not log in again")
+	}
+
+	var accessToken string
+	login.Param("accessToken", &accessToken)
+	if accessToken != "fluxtest-fresh-token" {
+		t.Fatalf("logged in again with %q rather than the new token", accessToken)
+	}
+
+	timeout := time.After(time.Second)
+	for loggedIn := false; !loggedIn; {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case LoggedIn:
+				loggedIn = true
+			case Connected, Disconnected, LoginFailed:
+				t.Fatalf("unexpected %T while refreshing the token", e)
+			}
+		case <-timeout:
+			t.Fatal("the new login was not accepted")
+		}
+	}
+	if srv.Connections() != 1 || !s.Established() {
+		t.Fatal("the connection was not kept open")
+	}
+}
+
+func TestTokenRefreshRejected(t *testing.T) {
+	srv := fluxtest.NewServer()
+	defer srv.Close()
+
+	s := newTestSession(t, srv, WithTokenRefresh(200*time.Millisecond, 100*time.Millisecond))
+	defer s.Close()
+	events := s.Events()
+
+	srv.RejectLogin("token expired")
+
+	failed, disconnected := false, false
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			switch e.(type) {
+			case LoginFailed:
+				failed = true
+			case Disconnected:
+				if !failed {
+					t.Fatal("disconnected before the login was rejected")
+				}
+				disconnected = true
+			case Reconnecting:
+				if !disconnected {
+					t.Fatal("reconnecting without having disconnected")
+				}
+
+				// let the reconnect through
+				srv.RejectLogin("")
+			case LoggedIn:
+				if disconnected {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("the session did not reconnect (login failed %t, disconnected %t)", failed, disconnected)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package flux
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is the connection that a Session speaks the provisioner protocol over,
+// it is satisfied by *websocket.Conn as well as the in-memory connections
+// handed out by a MemoryDialer
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	ReadJSON(v interface{}) error
+	WriteMessage(messageType int, data []byte) error
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
+// Dialer opens a Conn to the gateway URL, Open goes through the Dialer the
+// Session was created with (see WithDialer)
+type Dialer interface {
+	Dial(url string, header http.Header) (Conn, error)
+}
+
+// WebsocketDialer is the default Dialer, it dials the gateway using the
+// embedded gorilla websocket.Dialer (or websocket.DefaultDialer if nil)
+type WebsocketDialer struct {
+	*websocket.Dialer
+}
+
+// Dial opens a websocket connection to the gateway URL
+func (w WebsocketDialer) Dial(url string, header http.Header) (Conn, error) {
+	dialer := w.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+
+	conn, _, err := dialer.Dial(url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		return nil
+	})
+
+	return conn, nil
+}
@@ -60,7 +60,9 @@ type gatewayParams struct {
 	Symbols           []string     `json:"symbols,omitempty"`
 	QuoteFields       []QuoteField `json:"fields,omitempty"`
 	RefreshRate       int          `json:"refreshRate,omitempty"`
-	ExtendedHours     bool         `json:"extendedHours,omitempty"`
+
+	// ExtendedHours is only set for charts, which send it even when false
+	ExtendedHours *bool `json:"extendedHours,omitempty"`
 }
 
 type gatewayRequest struct {
@@ -72,6 +74,8 @@ type gatewayRequestLoad struct {
 	Payload []gatewayRequest `json:"payload"`
 }
 
-type heartbeat struct {
-	Heartbeat int64 `json:"heartbeat"`
+type loginResponse struct {
+	Authenticated bool   `json:"authenticated"`
+	Token         string `json:"token"`
+	Message       string `json:"message"`
 }
@@ -0,0 +1,36 @@
+package flux
+
+import "sync"
+
+// requestVersions counts the requests made for every spec (by short name),
+// the count is part of the request id so that each request gets a document of
+// its own
+type requestVersions struct {
+	mu   sync.Mutex
+	vers map[string]int
+}
+
+func newRequestVersions() *requestVersions {
+	return &requestVersions{
+		vers: make(map[string]int),
+	}
+}
+
+// next returns the version for a new request
+func (v *requestVersions) next(name string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ver := v.vers[name]
+	v.vers[name]++
+	return ver
+}
+
+// last returns the version of the latest request, false if none was made
+func (v *requestVersions) last(name string) (int, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ver, ok := v.vers[name]
+	return ver - 1, ok
+}